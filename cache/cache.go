@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/fileutil"
+)
+
+type Cache struct {
+	dir string
+	now func() time.Time
+}
+
+func New(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+		now: time.Now,
+	}
+}
+
+func Key(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get returns the cached credential, or nil if it is not cached or expires within the margin.
+func (c *Cache) Get(key string, margin time.Duration) (*credentials.CredentialOption, error) {
+	buf, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	opts := &credentials.CredentialOption{}
+	if err := json.Unmarshal(buf, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.ExpirationTimestamp == nil || !c.now().Add(margin).Before(*opts.ExpirationTimestamp) {
+		return nil, nil
+	}
+
+	return opts, nil
+}
+
+// Set stores the credential. Credentials without an expiration timestamp are not cached,
+// because there is no way to know when they become invalid.
+func (c *Cache) Set(key string, opts *credentials.CredentialOption) error {
+	if opts.ExpirationTimestamp == nil {
+		return nil
+	}
+
+	buf, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(c.path(key), buf, 0600)
+}
+
+func (c *Cache) Delete(key string) error {
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	expirationTimestamp := now.Add(10 * time.Minute)
+
+	type args struct {
+		opts   *credentials.CredentialOption
+		margin time.Duration
+	}
+	tests := []struct {
+		name string
+		args args
+		want *credentials.CredentialOption
+	}{
+		{
+			name: "hit",
+			args: args{
+				opts: &credentials.CredentialOption{
+					Token:               "token",
+					ExpirationTimestamp: &expirationTimestamp,
+				},
+				margin: time.Minute,
+			},
+			want: &credentials.CredentialOption{
+				Token:               "token",
+				ExpirationTimestamp: &expirationTimestamp,
+			},
+		},
+		{
+			name: "expires within margin",
+			args: args{
+				opts: &credentials.CredentialOption{
+					Token:               "token",
+					ExpirationTimestamp: &expirationTimestamp,
+				},
+				margin: 10 * time.Minute,
+			},
+			want: nil,
+		},
+		{
+			name: "without expiration timestamp is not cached",
+			args: args{
+				opts: &credentials.CredentialOption{
+					Token: "token",
+				},
+				margin: time.Minute,
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Errorf("TempDir() error = %v", err)
+				return
+			}
+			defer os.RemoveAll(testDir)
+
+			c := New(filepath.Join(testDir, "cache"))
+			c.now = func() time.Time { return now }
+			key := Key("args")
+
+			if err := c.Set(key, tt.args.opts); err != nil {
+				t.Errorf("Cache.Set() error = %v", err)
+				return
+			}
+			got, err := c.Get(key, tt.args.margin)
+			if err != nil {
+				t.Errorf("Cache.Get() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cache.Get() = %+v, want %+v", got, tt.want)
+			}
+
+			if err := c.Delete(key); err != nil {
+				t.Errorf("Cache.Delete() error = %v", err)
+				return
+			}
+			got, err = c.Get(key, tt.args.margin)
+			if err != nil || got != nil {
+				t.Errorf("Cache.Get() after Delete() = %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestCache_permission(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permission is not supported on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	expirationTimestamp := time.Now().Add(time.Hour)
+	c := New(filepath.Join(testDir, "cache"))
+	if err := c.Set(Key("args"), &credentials.CredentialOption{Token: "token", ExpirationTimestamp: &expirationTimestamp}); err != nil {
+		t.Errorf("Cache.Set() error = %v", err)
+		return
+	}
+
+	fi, err := os.Stat(c.path(Key("args")))
+	if err != nil {
+		t.Errorf("os.Stat() error = %v", err)
+		return
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("cache file mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+}
@@ -1,16 +1,28 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/Songmu/prompter"
-	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
 	"github.com/takumakume/kubectl-credentials-broker/credentials"
 	"github.com/takumakume/kubectl-credentials-broker/kubeconfig"
 )
 
-var defaultExecAPIVersion = (&credentials.V1Beta1{}).APIVersionString()
+var (
+	defaultExecAPIVersion  = (&credentials.V1{}).APIVersionString()
+	fallbackExecAPIVersion = (&credentials.V1Beta1{}).APIVersionString()
+)
+
+// client.authentication.k8s.io/v1 is available since kubectl v1.22
+const minKubectlMinorVersionForV1 = 22
 
 type kubeconfigCmdArgs struct {
 	execAPIVersion string
@@ -19,15 +31,7 @@ type kubeconfigCmdArgs struct {
 	rootCmdArgs
 }
 
-var (
-	argsKubeconfigClientCertificatePath string
-	argsKubeconfigClientKeyPath         string
-	argsKubeconfigTokenPath             string
-	argsKubeconfigBeforeExecCommand     string
-	argsKubeconfigExecAPIVersion        string
-	argsKubeconfigEnv                   map[string]string
-	argsKubeconfigForce                 bool
-)
+var argsKubeconfig kubeconfigCmdArgs
 
 var configCmd = &cobra.Command{
 	Use:   "kubeconfig",
@@ -40,33 +44,23 @@ var configSetCmd = &cobra.Command{
 	Short: "This command adds an exec command to the current-context user.",
 	Long:  "This command adds an exec command to the current-context user.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opt := &kubeconfigCmdArgs{
-			rootCmdArgs: rootCmdArgs{
-				clientCertificatePath: argsKubeconfigClientCertificatePath,
-				clientKeyPath:         argsKubeconfigClientKeyPath,
-				tokenPath:             argsKubeconfigTokenPath,
-				beforeExecCommand:     argsKubeconfigBeforeExecCommand,
-			},
-			execAPIVersion: argsKubeconfigExecAPIVersion,
-			env:            argsKubeconfigEnv,
-			force:          argsKubeconfigForce,
-		}
+		opt := &argsKubeconfig
 		if err := opt.validate(); err != nil {
 			return err
 		}
+		if opt.execAPIVersion == "" {
+			opt.execAPIVersion = detectExecAPIVersion()
+		}
 
 		return kubeconfigSet(opt)
 	},
 }
 
 func init() {
-	configSetCmd.Flags().StringVarP(&argsKubeconfigClientCertificatePath, "client-certificate-path", "", "", "PEM-encoded client certificate file path. Can contain CA certificate. If this flag is specified, --client-key-path is also required. (optional)")
-	configSetCmd.Flags().StringVarP(&argsKubeconfigClientKeyPath, "client-key-path", "", "", "PEM-encoded client key file path. (optional)")
-	configSetCmd.Flags().StringVarP(&argsKubeconfigTokenPath, "token-path", "", "", "Token file path. (optional)")
-	configSetCmd.Flags().StringVarP(&argsKubeconfigBeforeExecCommand, "before-exec-command", "", "", "A command line to run before responding to the credential plugin. For example, it can be used to update certificate and token files. (optional)")
-	configSetCmd.Flags().StringVarP(&argsKubeconfigExecAPIVersion, "exec-api-version", "", defaultExecAPIVersion, fmt.Sprintf("API version to use when decoding the ExecCredentials resource (Default: %s)", defaultExecAPIVersion))
-	configSetCmd.Flags().StringToStringVarP(&argsKubeconfigEnv, "env", "", map[string]string{}, "Environment variables to set when running the plugin. (optional) ex. 'HOGE=huga,FOO=bar'")
-	configSetCmd.Flags().BoolVarP(&argsKubeconfigForce, "force", "f", false, "Do not confirm overwriting of kubeconfig (Default: false)")
+	addRootCmdArgsFlags(configSetCmd.Flags(), &argsKubeconfig.rootCmdArgs)
+	configSetCmd.Flags().StringVarP(&argsKubeconfig.execAPIVersion, "exec-api-version", "", "", fmt.Sprintf("API version to use when decoding the ExecCredentials resource (Default: %s if supported by kubectl, otherwise %s)", defaultExecAPIVersion, fallbackExecAPIVersion))
+	configSetCmd.Flags().StringToStringVarP(&argsKubeconfig.env, "env", "", map[string]string{}, "Environment variables to set when running the plugin. (optional) ex. 'HOGE=huga,FOO=bar'")
+	configSetCmd.Flags().BoolVarP(&argsKubeconfig.force, "force", "f", false, "Do not confirm overwriting of kubeconfig (Default: false)")
 	configCmd.AddCommand(configSetCmd)
 	rootCmd.AddCommand(configCmd)
 }
@@ -78,26 +72,216 @@ func (args *kubeconfigCmdArgs) validate() error {
 	return nil
 }
 
+func detectExecAPIVersion() string {
+	buf, err := exec.Command("kubectl", "version", "--client", "--output", "json").Output()
+	if err != nil {
+		return fallbackExecAPIVersion
+	}
+
+	v, err := execAPIVersionFromKubectlVersion(buf)
+	if err != nil {
+		return fallbackExecAPIVersion
+	}
+
+	return v
+}
+
+func execAPIVersionFromKubectlVersion(buf []byte) (string, error) {
+	v := struct {
+		ClientVersion struct {
+			Major string `json:"major"`
+			Minor string `json:"minor"`
+		} `json:"clientVersion"`
+	}{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return "", err
+	}
+
+	major, err := strconv.Atoi(strings.TrimRight(v.ClientVersion.Major, "+"))
+	if err != nil {
+		return "", fmt.Errorf("invalid kubectl major version: %s", v.ClientVersion.Major)
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(v.ClientVersion.Minor, "+"))
+	if err != nil {
+		return "", fmt.Errorf("invalid kubectl minor version: %s", v.ClientVersion.Minor)
+	}
+
+	if major > 1 || (major == 1 && minor >= minKubectlMinorVersionForV1) {
+		return defaultExecAPIVersion, nil
+	}
+
+	return fallbackExecAPIVersion, nil
+}
+
 func (args *kubeconfigCmdArgs) makePluginCommand() ([]string, error) {
 	c := []string{commandName}
 
 	if len(args.beforeExecCommand) > 0 {
-		quotedCmd, err := shellquote.Split(args.beforeExecCommand)
-		if err != nil {
-			return nil, err
-		}
-		c = append(c, "--before-exec-command")
-		c = append(c, quotedCmd...)
+		c = append(c, "--before-exec-command", args.beforeExecCommand)
+	}
+	if args.beforeExecShell {
+		c = append(c, "--before-exec-shell")
+	}
+	if args.beforeExecTimeout > 0 {
+		c = append(c, "--before-exec-timeout", args.beforeExecTimeout.String())
+	}
+	if args.beforeExecRetries > 0 {
+		c = append(c, "--before-exec-retries", strconv.Itoa(args.beforeExecRetries))
+	}
+	if len(args.beforeExecWhen) > 0 && args.beforeExecWhen != beforeExecWhenAlways {
+		c = append(c, "--before-exec-when", args.beforeExecWhen)
+	}
+	if args.refreshMargin != defaultRefreshMargin {
+		c = append(c, "--refresh-margin", args.refreshMargin.String())
+	}
+	if args.allowExpired {
+		c = append(c, "--allow-expired")
+	}
+	if len(args.permissions) > 0 && args.permissions != permissionsStrict {
+		c = append(c, "--permissions", args.permissions)
 	}
 	if len(args.clientCertificatePath) > 0 {
 		c = append(c, "--client-certificate-path", args.clientCertificatePath)
 	}
+	if len(args.clientCertificateEnv) > 0 {
+		c = append(c, "--client-certificate-env", args.clientCertificateEnv)
+	}
 	if len(args.clientKeyPath) > 0 {
 		c = append(c, "--client-key-path", args.clientKeyPath)
 	}
+	if len(args.clientKeyEnv) > 0 {
+		c = append(c, "--client-key-env", args.clientKeyEnv)
+	}
+	if len(args.clientKeyPassphraseEnv) > 0 {
+		c = append(c, "--client-key-passphrase-env", args.clientKeyPassphraseEnv)
+	}
+	if len(args.clientKeyPassphraseCommand) > 0 {
+		c = append(c, "--client-key-passphrase-command", args.clientKeyPassphraseCommand)
+	}
+	if len(args.clientPKCS12Path) > 0 {
+		c = append(c, "--client-pkcs12-path", args.clientPKCS12Path)
+	}
+	if len(args.clientPKCS12PassphraseEnv) > 0 {
+		c = append(c, "--client-pkcs12-passphrase-env", args.clientPKCS12PassphraseEnv)
+	}
+	if len(args.clientPKCS12PassphraseCommand) > 0 {
+		c = append(c, "--client-pkcs12-passphrase-command", args.clientPKCS12PassphraseCommand)
+	}
+	if len(args.clientBundlePath) > 0 {
+		c = append(c, "--client-bundle-path", args.clientBundlePath)
+	}
 	if len(args.tokenPath) > 0 {
 		c = append(c, "--token-path", args.tokenPath)
 	}
+	if len(args.tokenEnv) > 0 {
+		c = append(c, "--token-env", args.tokenEnv)
+	}
+	if len(args.tokenCommand) > 0 {
+		c = append(c, "--token-command", args.tokenCommand)
+	}
+	if args.commandTimeout > 0 {
+		c = append(c, "--command-timeout", args.commandTimeout.String())
+	}
+	if len(args.tokenURL) > 0 {
+		c = append(c, "--token-url", args.tokenURL)
+		if len(args.tokenURLMethod) > 0 && args.tokenURLMethod != http.MethodGet {
+			c = append(c, "--token-url-method", args.tokenURLMethod)
+		}
+		for _, h := range args.tokenURLHeaders {
+			c = append(c, "--token-url-header", h)
+		}
+		if len(args.tokenURLBody) > 0 {
+			c = append(c, "--token-url-body", args.tokenURLBody)
+		}
+		if len(args.tokenURLCAPath) > 0 {
+			c = append(c, "--token-url-ca-path", args.tokenURLCAPath)
+		}
+		if len(args.tokenURLClientCertificatePath) > 0 {
+			c = append(c, "--token-url-client-certificate-path", args.tokenURLClientCertificatePath)
+		}
+		if len(args.tokenURLClientKeyPath) > 0 {
+			c = append(c, "--token-url-client-key-path", args.tokenURLClientKeyPath)
+		}
+		if len(args.tokenURLField) > 0 && args.tokenURLField != defaultTokenURLField {
+			c = append(c, "--token-url-field", args.tokenURLField)
+		}
+		if len(args.tokenURLExpirationField) > 0 {
+			c = append(c, "--token-url-expiration-field", args.tokenURLExpirationField)
+		}
+		if len(args.tokenURLExpiresInField) > 0 {
+			c = append(c, "--token-url-expires-in-field", args.tokenURLExpiresInField)
+		}
+	}
+	if len(args.oidcIssuerURL) > 0 {
+		c = append(c, "--oidc-issuer-url", args.oidcIssuerURL, "--oidc-client-id", args.oidcClientID)
+		if len(args.oidcClientSecret) > 0 {
+			c = append(c, "--oidc-client-secret", args.oidcClientSecret)
+		}
+		for _, scope := range args.oidcExtraScopes {
+			c = append(c, "--oidc-extra-scope", scope)
+		}
+		if len(args.oidcRefreshTokenPath) > 0 {
+			c = append(c, "--oidc-refresh-token-path", args.oidcRefreshTokenPath)
+		}
+		if len(args.oidcCAPath) > 0 {
+			c = append(c, "--oidc-ca-path", args.oidcCAPath)
+		}
+	}
+	if len(args.tokenRequestServiceAccount) > 0 {
+		c = append(c, "--token-request-service-account", args.tokenRequestServiceAccount)
+		if len(args.tokenRequestKubeconfig) > 0 {
+			c = append(c, "--token-request-kubeconfig", args.tokenRequestKubeconfig)
+		}
+		if len(args.tokenRequestContext) > 0 {
+			c = append(c, "--token-request-context", args.tokenRequestContext)
+		}
+		if len(args.tokenRequestNamespace) > 0 {
+			c = append(c, "--token-request-namespace", args.tokenRequestNamespace)
+		}
+		for _, audience := range args.tokenRequestAudiences {
+			c = append(c, "--token-request-audience", audience)
+		}
+		if args.tokenRequestDuration > 0 {
+			c = append(c, "--token-request-duration", args.tokenRequestDuration.String())
+		}
+	}
+	if len(args.csrSignerName) > 0 {
+		c = append(c, "--csr-signer-name", args.csrSignerName)
+		if len(args.csrKubeconfig) > 0 {
+			c = append(c, "--csr-kubeconfig", args.csrKubeconfig)
+		}
+		if len(args.csrContext) > 0 {
+			c = append(c, "--csr-context", args.csrContext)
+		}
+		if len(args.csrUsername) > 0 {
+			c = append(c, "--csr-username", args.csrUsername)
+		}
+		for _, group := range args.csrGroups {
+			c = append(c, "--csr-group", group)
+		}
+		if args.csrDuration > 0 {
+			c = append(c, "--csr-duration", args.csrDuration.String())
+		}
+	}
+	if len(args.certRenewURL) > 0 {
+		c = append(c, "--cert-renew-url", args.certRenewURL)
+		if len(args.certRenewCAPath) > 0 {
+			c = append(c, "--cert-renew-ca-path", args.certRenewCAPath)
+		}
+	}
+	for _, spec := range args.customSources {
+		c = append(c, "--source", spec)
+	}
+	if args.noCache {
+		c = append(c, "--no-cache")
+	}
+	// An empty --cache-dir and 0 margins are kept, because they differ from the defaults.
+	if args.cacheDir != defaultCacheDir {
+		c = append(c, "--cache-dir", args.cacheDir)
+	}
+	if args.cacheExpirationMargin != defaultCacheExpirationMargin {
+		c = append(c, "--cache-expiration-margin", args.cacheExpirationMargin.String())
+	}
 
 	return c, nil
 }
@@ -110,6 +294,11 @@ func kubeconfigSet(args *kubeconfigCmdArgs) error {
 		return err
 	}
 
+	// Also when the kubeconfig is up to date, e.g. the directories were removed.
+	if err := args.createCredentialDirs(); err != nil {
+		return err
+	}
+
 	diff, err := k.UpdateCurrentUserExecConfigDryRun(args.execAPIVersion, "kubectl", pluginCmd, args.env)
 	if err != nil {
 		return err
@@ -140,17 +329,24 @@ func kubeconfigSet(args *kubeconfigCmdArgs) error {
 	return nil
 }
 
-func splitCommand(commandline string) (string, []string, error) {
-	parsedCmd, err := shellquote.Split(commandline)
-	if err != nil {
-		return "", []string{}, err
+// createCredentialDirs creates the missing directories of the credential files with 0700,
+// so that the files written there later pass the permission check.
+func (args *kubeconfigCmdArgs) createCredentialDirs() error {
+	paths := args.privateFiles()
+	if len(args.clientCertificatePath) > 0 {
+		paths = append(paths, args.clientCertificatePath)
 	}
 
-	if len(parsedCmd) == 0 {
-		return "", []string{}, nil
-	} else if len(parsedCmd) == 1 {
-		return parsedCmd[0], []string{}, nil
-	} else {
-		return parsedCmd[0], parsedCmd[1:], nil
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		fmt.Printf("created directory %s\n", dir)
 	}
+
+	return nil
 }
@@ -1,59 +1,522 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
+	"time"
 )
 
-func Test_splitCommand(t *testing.T) {
+func Test_execAPIVersionFromKubectlVersion(t *testing.T) {
 	type args struct {
-		commandline string
+		buf []byte
 	}
 	tests := []struct {
-		name     string
-		args     args
-		wantCmd  string
-		wantArgs []string
-		wantErr  bool
+		name    string
+		args    args
+		want    string
+		wantErr bool
 	}{
 		{
-			name: "command with args",
+			name: "kubectl v1.22",
 			args: args{
-				commandline: "/cmd -args1 -args2",
+				buf: []byte(`{"clientVersion":{"major":"1","minor":"22","gitVersion":"v1.22.0"}}`),
 			},
-			wantCmd:  "/cmd",
-			wantArgs: []string{"-args1", "-args2"},
+			want: "client.authentication.k8s.io/v1",
 		},
 		{
-			name: "command only",
+			name: "kubectl v1.23 (minor with suffix)",
 			args: args{
-				commandline: "/cmd",
+				buf: []byte(`{"clientVersion":{"major":"1","minor":"23+","gitVersion":"v1.23.4-eks"}}`),
 			},
-			wantCmd:  "/cmd",
-			wantArgs: []string{},
+			want: "client.authentication.k8s.io/v1",
 		},
 		{
-			name: "empty",
+			name: "kubectl v1.21",
 			args: args{
-				commandline: "",
+				buf: []byte(`{"clientVersion":{"major":"1","minor":"21","gitVersion":"v1.21.0"}}`),
 			},
-			wantCmd:  "",
-			wantArgs: []string{},
+			want: "client.authentication.k8s.io/v1beta1",
+		},
+		{
+			name: "invalid version",
+			args: args{
+				buf: []byte(`{"clientVersion":{}}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid json",
+			args: args{
+				buf: []byte(`error`),
+			},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := splitCommand(tt.args.commandline)
+			got, err := execAPIVersionFromKubectlVersion(tt.args.buf)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("splitCommand() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("execAPIVersionFromKubectlVersion() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.wantCmd {
-				t.Errorf("splitCommand() got = %v, want %v", got, tt.wantCmd)
+			if got != tt.want {
+				t.Errorf("execAPIVersionFromKubectlVersion() = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got1, tt.wantArgs) {
-				t.Errorf("splitCommand() got1 = %+v, want %+v", got1, tt.wantArgs)
+		})
+	}
+}
+
+func Test_kubeconfigCmdArgs_makePluginCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    kubeconfigCmdArgs
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "token",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "certificate and key with cache options",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientCertificatePath: "/path/to/tls.crt",
+					clientKeyPath:         "/path/to/tls.key",
+					cacheDir:              "/path/to/cache",
+					cacheExpirationMargin: 5 * time.Minute,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-certificate-path", "/path/to/tls.crt", "--client-key-path", "/path/to/tls.key", "--cache-dir", "/path/to/cache", "--cache-expiration-margin", "5m0s"},
+		},
+		{
+			name: "before-exec-command with quoted args",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					beforeExecCommand:     "/path/to/refresh.sh --name 'my cluster'",
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--before-exec-command", "/path/to/refresh.sh --name 'my cluster'", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "before-exec-command with shell",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					beforeExecCommand:     "/path/to/refresh.sh | tee /tmp/log",
+					beforeExecShell:       true,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--before-exec-command", "/path/to/refresh.sh | tee /tmp/log", "--before-exec-shell", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "before-exec-command with timeout and retries",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					beforeExecCommand:     "/path/to/refresh.sh",
+					beforeExecTimeout:     30 * time.Second,
+					beforeExecRetries:     2,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--before-exec-command", "/path/to/refresh.sh", "--before-exec-timeout", "30s", "--before-exec-retries", "2", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "before-exec-when",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					beforeExecCommand:     "/path/to/refresh.sh",
+					beforeExecWhen:        "expiring",
+					refreshMargin:         10 * time.Minute,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--before-exec-command", "/path/to/refresh.sh", "--before-exec-when", "expiring", "--refresh-margin", "10m0s", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "allow-expired",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					allowExpired:          true,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--allow-expired", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "permissions",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					permissions:           "warn",
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--permissions", "warn", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "default permissions",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					permissions:           permissionsStrict,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-path", "/path/to/token"},
+		},
+		{
+			name: "token-command",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenCommand:          "/path/to/print-token.sh --name 'my cluster'",
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-command", "/path/to/print-token.sh --name 'my cluster'"},
+		},
+		{
+			name: "command-timeout",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenCommand:          "/path/to/print-token.sh",
+					commandTimeout:        30 * time.Second,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-command", "/path/to/print-token.sh", "--command-timeout", "30s"},
+		},
+		{
+			name: "token-url",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenURL:                      "https://example.com/token",
+					tokenURLMethod:                "POST",
+					tokenURLHeaders:               []string{"X-Cluster: cluster1"},
+					tokenURLCAPath:                "/path/to/ca.crt",
+					tokenURLClientCertificatePath: "/path/to/tls.crt",
+					tokenURLClientKeyPath:         "/path/to/tls.key",
+					tokenURLField:                 "status.token",
+					tokenURLExpirationField:       "status.expirationTimestamp",
+					cacheDir:                      defaultCacheDir,
+					cacheExpirationMargin:         defaultCacheExpirationMargin,
+					refreshMargin:                 defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-url", "https://example.com/token", "--token-url-method", "POST", "--token-url-header", "X-Cluster: cluster1", "--token-url-ca-path", "/path/to/ca.crt", "--token-url-client-certificate-path", "/path/to/tls.crt", "--token-url-client-key-path", "/path/to/tls.key", "--token-url-field", "status.token", "--token-url-expiration-field", "status.expirationTimestamp"},
+		},
+		{
+			name: "oidc",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					oidcIssuerURL:         "https://issuer.example.com",
+					oidcClientID:          "kubernetes",
+					oidcExtraScopes:       []string{"email", "groups"},
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--oidc-issuer-url", "https://issuer.example.com", "--oidc-client-id", "kubernetes", "--oidc-extra-scope", "email", "--oidc-extra-scope", "groups"},
+		},
+		{
+			name: "token-request",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenRequestServiceAccount: "developer",
+					tokenRequestKubeconfig:     "/path/to/bootstrap.kubeconfig",
+					tokenRequestContext:        "management",
+					tokenRequestNamespace:      "tenant1",
+					tokenRequestAudiences:      []string{"https://workload.example.com"},
+					tokenRequestDuration:       time.Hour,
+					cacheDir:                   defaultCacheDir,
+					cacheExpirationMargin:      defaultCacheExpirationMargin,
+					refreshMargin:              defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-request-service-account", "developer", "--token-request-kubeconfig", "/path/to/bootstrap.kubeconfig", "--token-request-context", "management", "--token-request-namespace", "tenant1", "--token-request-audience", "https://workload.example.com", "--token-request-duration", "1h0m0s"},
+		},
+		{
+			name: "csr",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientCertificatePath: "/path/to/tls.crt",
+					clientKeyPath:         "/path/to/tls.key",
+					csrSignerName:         "kubernetes.io/kube-apiserver-client",
+					csrKubeconfig:         "/path/to/bootstrap.kubeconfig",
+					csrUsername:           "user1",
+					csrGroups:             []string{"developers"},
+					csrDuration:           24 * time.Hour,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-certificate-path", "/path/to/tls.crt", "--client-key-path", "/path/to/tls.key", "--csr-signer-name", "kubernetes.io/kube-apiserver-client", "--csr-kubeconfig", "/path/to/bootstrap.kubeconfig", "--csr-username", "user1", "--csr-group", "developers", "--csr-duration", "24h0m0s"},
+		},
+		{
+			name: "cert-renew-url",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientCertificatePath: "/path/to/tls.crt",
+					clientKeyPath:         "/path/to/tls.key",
+					certRenewURL:          "https://ca.example.com/renew",
+					certRenewCAPath:       "/path/to/ca.crt",
+					refreshMargin:         time.Hour,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--refresh-margin", "1h0m0s", "--client-certificate-path", "/path/to/tls.crt", "--client-key-path", "/path/to/tls.key", "--cert-renew-url", "https://ca.example.com/renew", "--cert-renew-ca-path", "/path/to/ca.crt"},
+		},
+		{
+			name: "client-key-passphrase-command",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientCertificatePath:      "/path/to/tls.crt",
+					clientKeyPath:              "/path/to/tls.key",
+					clientKeyPassphraseCommand: "pass show kube/tls.key",
+					cacheDir:                   defaultCacheDir,
+					cacheExpirationMargin:      defaultCacheExpirationMargin,
+					refreshMargin:              defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-certificate-path", "/path/to/tls.crt", "--client-key-path", "/path/to/tls.key", "--client-key-passphrase-command", "pass show kube/tls.key"},
+		},
+		{
+			name: "client-pkcs12-path",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientPKCS12Path:          "/path/to/client.p12",
+					clientPKCS12PassphraseEnv: "PKCS12_PASSPHRASE",
+					cacheDir:                  defaultCacheDir,
+					cacheExpirationMargin:     defaultCacheExpirationMargin,
+					refreshMargin:             defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-pkcs12-path", "/path/to/client.p12", "--client-pkcs12-passphrase-env", "PKCS12_PASSPHRASE"},
+		},
+		{
+			name: "client-bundle-path",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientBundlePath:       "/path/to/client.pem",
+					clientKeyPassphraseEnv: "CLIENT_KEY_PASSPHRASE",
+					cacheDir:               defaultCacheDir,
+					cacheExpirationMargin:  defaultCacheExpirationMargin,
+					refreshMargin:          defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-key-passphrase-env", "CLIENT_KEY_PASSPHRASE", "--client-bundle-path", "/path/to/client.pem"},
+		},
+		{
+			name: "env",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					clientCertificateEnv:  "CLIENT_CERTIFICATE",
+					clientKeyEnv:          "CLIENT_KEY",
+					tokenEnv:              "TOKEN",
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--client-certificate-env", "CLIENT_CERTIFICATE", "--client-key-env", "CLIENT_KEY", "--token-env", "TOKEN"},
+		},
+		{
+			name: "custom sources",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					customSources:         []string{"file:slot=client-certificate,path=/path/to/tls.crt", "file:slot=client-key,path=/path/to/tls.key"},
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--source", "file:slot=client-certificate,path=/path/to/tls.crt", "--source", "file:slot=client-key,path=/path/to/tls.key"},
+		},
+		{
+			name: "no-cache",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					noCache:               true,
+					cacheDir:              defaultCacheDir,
+					cacheExpirationMargin: defaultCacheExpirationMargin,
+					refreshMargin:         defaultRefreshMargin,
+				},
+			},
+			want: []string{"credentials-broker", "--token-path", "/path/to/token", "--no-cache"},
+		},
+		{
+			name: "explicit zero values",
+			args: kubeconfigCmdArgs{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath:             "/path/to/token",
+					cacheDir:              "",
+					cacheExpirationMargin: 0,
+					refreshMargin:         0,
+				},
+			},
+			want: []string{"credentials-broker", "--refresh-margin", "0s", "--token-path", "/path/to/token", "--cache-dir", "", "--cache-expiration-margin", "0s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.args.makePluginCommand()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("kubeconfigCmdArgs.makePluginCommand() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kubeconfigCmdArgs.makePluginCommand() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_kubeconfigCmdArgs_createCredentialDirs(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	existingDir := filepath.Join(testDir, "existing")
+	if err := os.Mkdir(existingDir, 0755); err != nil {
+		t.Errorf("os.Mkdir() error = %v", err)
+		return
+	}
+
+	args := &kubeconfigCmdArgs{
+		rootCmdArgs: rootCmdArgs{
+			clientCertificatePath: filepath.Join(testDir, "certs", "tls.crt"),
+			clientKeyPath:         filepath.Join(testDir, "keys", "tls.key"),
+			tokenPath:             filepath.Join(existingDir, "token"),
+		},
+	}
+	if err := args.createCredentialDirs(); err != nil {
+		t.Errorf("createCredentialDirs() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		dir  string
+		want os.FileMode
+	}{
+		{dir: filepath.Join(testDir, "certs"), want: 0700},
+		{dir: filepath.Join(testDir, "keys"), want: 0700},
+		{dir: existingDir, want: 0755},
+	}
+	for _, tt := range tests {
+		fi, err := os.Stat(tt.dir)
+		if err != nil {
+			t.Errorf("os.Stat() error = %v", err)
+			continue
+		}
+		if !fi.IsDir() {
+			t.Errorf("%s is not a directory", tt.dir)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != tt.want {
+			t.Errorf("%s permissions = %04o, want %04o", tt.dir, fi.Mode().Perm(), tt.want)
+		}
+	}
+}
+
+func Test_kubeconfigSet_createCredentialDirs(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	kubeconfigPath := filepath.Join(testDir, "kubeconfig")
+	kubeconfigData := `---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    user: user1
+  name: context1
+users:
+- name: user1
+  user:
+    token: XXXXXXXX`
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfigData), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	os.Setenv("KUBECONFIG", kubeconfigPath)
+	defer os.Unsetenv("KUBECONFIG")
+
+	tokenDir := filepath.Join(testDir, "tokens")
+	args := &kubeconfigCmdArgs{
+		execAPIVersion: "client.authentication.k8s.io/v1",
+		force:          true,
+		rootCmdArgs: rootCmdArgs{
+			tokenPath:   filepath.Join(tokenDir, "token"),
+			permissions: permissionsStrict,
+		},
+	}
+	if err := kubeconfigSet(args); err != nil {
+		t.Errorf("kubeconfigSet() error = %v", err)
+		return
+	}
+	if err := os.RemoveAll(tokenDir); err != nil {
+		t.Errorf("os.RemoveAll() error = %v", err)
+		return
+	}
+
+	// The kubeconfig is up to date now.
+	if err := kubeconfigSet(args); err != nil {
+		t.Errorf("kubeconfigSet() error = %v", err)
+		return
+	}
+	if _, err := os.Stat(tokenDir); err != nil {
+		t.Errorf("kubeconfigSet() did not create the directory of the token: %v", err)
+	}
+}
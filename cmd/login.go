@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/takumakume/kubectl-credentials-broker/lock"
+)
+
+var argsLogin rootCmdArgs
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "This command logs in to the OIDC provider with the device authorization grant.",
+	Long:  "This command logs in to the OIDC provider with the device authorization grant, and stores the refresh token used by --oidc-issuer-url. It works on hosts without a browser.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return login(&argsLogin)
+	},
+}
+
+func init() {
+	addOIDCFlags(loginCmd.Flags(), &argsLogin)
+	rootCmd.AddCommand(loginCmd)
+}
+
+func login(args *rootCmdArgs) error {
+	if len(args.oidcIssuerURL) == 0 {
+		return errors.New("oidc-issuer-url is required")
+	}
+	s := args.oidcSource()
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	// Do not race with a refresh redeeming the same refresh token.
+	l, err := lock.Acquire(refreshTokenLockPath(s))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to acquire lock: %s\n", err)
+	} else {
+		defer l.Release()
+	}
+
+	if err := s.Login(context.Background(), os.Stderr); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in. The refresh token is stored in %s\n", s.RefreshTokenPath)
+
+	return nil
+}
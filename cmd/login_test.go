@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+	"github.com/takumakume/kubectl-credentials-broker/lock"
+)
+
+// newOIDCServer returns an OIDC provider which approves the device authorization immediately.
+func newOIDCServer(t *testing.T, idToken string) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer":%q,"token_endpoint":%q,"device_authorization_endpoint":%q}`, server.URL, server.URL+"/token", server.URL+"/device")
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"device_code":"device-code1","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600,"interval":1}`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.PostForm.Get("device_code") == "device-code1", r.PostForm.Get("refresh_token") == "refresh-token1":
+			fmt.Fprintf(w, `{"id_token":%q,"refresh_token":"refresh-token1"}`, idToken)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+		}
+	})
+	server = httptest.NewServer(mux)
+
+	return server
+}
+
+func TestLogin(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	server := newOIDCServer(t, "id-token1")
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		args    *rootCmdArgs
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			args: &rootCmdArgs{
+				oidcIssuerURL:        server.URL,
+				oidcClientID:         "client1",
+				oidcRefreshTokenPath: filepath.Join(testDir, "refresh-token"),
+			},
+		},
+		{
+			name: "without issuer",
+			args: &rootCmdArgs{
+				oidcClientID:         "client1",
+				oidcRefreshTokenPath: filepath.Join(testDir, "refresh-token"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "without client id",
+			args: &rootCmdArgs{
+				oidcIssuerURL:        server.URL,
+				oidcRefreshTokenPath: filepath.Join(testDir, "refresh-token"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := login(tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			buf, err := ioutil.ReadFile(tt.args.oidcRefreshTokenPath)
+			if err != nil {
+				t.Errorf("ioutil.ReadFile() error = %v", err)
+				return
+			}
+			if strings.TrimSpace(string(buf)) != "refresh-token1" {
+				t.Errorf("stored refresh token = %q, want %q", string(buf), "refresh-token1")
+			}
+		})
+	}
+}
+
+func TestLogin_lock(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	server := newOIDCServer(t, "id-token1")
+	defer server.Close()
+
+	args := &rootCmdArgs{
+		oidcIssuerURL:        server.URL,
+		oidcClientID:         "client1",
+		oidcRefreshTokenPath: filepath.Join(testDir, "refresh-token"),
+	}
+	l, err := lock.Acquire(refreshTokenLockPath(args.oidcSource()))
+	if err != nil {
+		t.Errorf("lock.Acquire() error = %v", err)
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- login(args)
+	}()
+
+	select {
+	case err := <-done:
+		t.Errorf("login() = %v while the refresh token is locked", err)
+		l.Release()
+		return
+	// Longer than the login takes with the polling interval of the test server.
+	case <-time.After(2 * time.Second):
+	}
+
+	l.Release()
+	if err := <-done; err != nil {
+		t.Errorf("login() error = %v", err)
+	}
+}
+
+func TestRun_oidcLogin(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	idToken := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	server := newOIDCServer(t, idToken)
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		interactive  bool
+		refreshToken string
+		wantErr      bool
+	}{
+		{
+			name:         "refresh",
+			refreshToken: "refresh-token1",
+		},
+		{
+			name:        "login when interactive",
+			interactive: true,
+		},
+		{
+			name:    "no login when not interactive",
+			wantErr: true,
+		},
+		{
+			name:         "login when refresh token is expired",
+			interactive:  true,
+			refreshToken: "refresh-token0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("KUBERNETES_EXEC_INFO", fmt.Sprintf(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":%t}}`, tt.interactive))
+			defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+			refreshTokenPath := filepath.Join(testDir, tt.name, "refresh-token")
+			if len(tt.refreshToken) > 0 {
+				if err := os.MkdirAll(filepath.Dir(refreshTokenPath), 0700); err != nil {
+					t.Errorf("os.MkdirAll() error = %v", err)
+					return
+				}
+				if err := ioutil.WriteFile(refreshTokenPath, []byte(tt.refreshToken), 0600); err != nil {
+					t.Errorf("ioutil.WriteFile() error = %v", err)
+					return
+				}
+			}
+
+			args := &rootCmdArgs{
+				oidcIssuerURL:        server.URL,
+				oidcClientID:         "client1",
+				oidcRefreshTokenPath: refreshTokenPath,
+				noCache:              true,
+			}
+			runner, err := newRootCmdRunner(args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			got, err := runner.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "login") {
+					t.Errorf("run() error = %v, want a hint to log in", err)
+				}
+				return
+			}
+			if !strings.Contains(string(got), idToken) {
+				t.Errorf("run() = %v, want token %v", string(got), idToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/command"
+	"golang.org/x/term"
+)
+
+// passphraseSource reads a passphrase from an environment variable, the stdout of a command,
+// or the terminal if kubectl allows the plugin to interact with the user.
+type passphraseSource struct {
+	// name is what the passphrase is for, e.g. 'client key'.
+	name string
+	// flag is the prefix of the flags, e.g. 'client-key-passphrase'.
+	flag        string
+	env         string
+	command     string
+	timeout     time.Duration
+	interactive bool
+}
+
+func (args *rootCmdArgs) clientKeyPassphraseSource(interactive bool) *passphraseSource {
+	return &passphraseSource{
+		name:        "client key",
+		flag:        "client-key-passphrase",
+		env:         args.clientKeyPassphraseEnv,
+		command:     args.clientKeyPassphraseCommand,
+		timeout:     args.commandTimeout,
+		interactive: interactive,
+	}
+}
+
+func (args *rootCmdArgs) clientPKCS12PassphraseSource(interactive bool) *passphraseSource {
+	return &passphraseSource{
+		name:        "client PKCS#12 file",
+		flag:        "client-pkcs12-passphrase",
+		env:         args.clientPKCS12PassphraseEnv,
+		command:     args.clientPKCS12PassphraseCommand,
+		timeout:     args.commandTimeout,
+		interactive: interactive,
+	}
+}
+
+func (p *passphraseSource) read() ([]byte, error) {
+	switch {
+	case len(p.env) > 0:
+		v := os.Getenv(p.env)
+		if len(v) == 0 {
+			return nil, fmt.Errorf("environment variable %s for the %s passphrase is not set", p.env, p.name)
+		}
+		return []byte(v), nil
+	case len(p.command) > 0:
+		c := &command.Command{
+			Cmdline: p.command,
+			Timeout: p.timeout,
+			Stderr:  os.Stderr,
+		}
+		buf, err := c.Run(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("%s-command: %w", p.flag, err)
+		}
+		return []byte(strings.TrimRight(string(buf), "\r\n")), nil
+	case p.interactive:
+		return readPassword(fmt.Sprintf("Enter passphrase for the %s: ", p.name))
+	default:
+		return nil, fmt.Errorf("%s is encrypted, specify %s-env or %s-command, or run kubectl interactively to enter the passphrase", p.name, p.flag, p.flag)
+	}
+}
+
+// readPassword prompts on stderr, because stdout is read by kubectl.
+var readPassword = func(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_passphraseSource_read(t *testing.T) {
+	os.Setenv("TEST_PASSPHRASE", "passphrase-from-env")
+	defer os.Unsetenv("TEST_PASSPHRASE")
+
+	defaultReadPassword := readPassword
+	defer func() { readPassword = defaultReadPassword }()
+	readPassword = func(prompt string) ([]byte, error) {
+		return []byte("passphrase-from-prompt"), nil
+	}
+
+	tests := []struct {
+		name    string
+		source  *passphraseSource
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "env",
+			source: &passphraseSource{env: "TEST_PASSPHRASE"},
+			want:   "passphrase-from-env",
+		},
+		{
+			name:    "env not set",
+			source:  &passphraseSource{env: "TEST_PASSPHRASE_NOT_SET", interactive: true},
+			wantErr: true,
+		},
+		{
+			name:   "command",
+			source: &passphraseSource{command: "echo passphrase-from-command"},
+			want:   "passphrase-from-command",
+		},
+		{
+			name:    "command failed",
+			source:  &passphraseSource{command: "false"},
+			wantErr: true,
+		},
+		{
+			name:    "command timed out",
+			source:  &passphraseSource{command: "sleep 10", timeout: 100 * time.Millisecond},
+			wantErr: true,
+		},
+		{
+			name:   "prompt",
+			source: &passphraseSource{interactive: true},
+			want:   "passphrase-from-prompt",
+		},
+		{
+			name:    "not interactive",
+			source:  &passphraseSource{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && len(tt.source.command) > 0 {
+				t.Skip("echo and false are not executables on Windows")
+			}
+
+			got, err := tt.source.read()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("passphraseSource.read() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("passphraseSource.read() = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
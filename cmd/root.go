@@ -1,41 +1,66 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/takumakume/kubectl-credentials-broker/cache"
+	"github.com/takumakume/kubectl-credentials-broker/command"
 	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/fileutil"
 	"github.com/takumakume/kubectl-credentials-broker/kubeconfig"
+	"github.com/takumakume/kubectl-credentials-broker/lock"
+	"github.com/takumakume/kubectl-credentials-broker/sources"
+	"k8s.io/client-go/util/homedir"
 )
 
 var Version = "dev"
 
-var (
-	argsClientCertificatePath string
-	argsClientKeyPath         string
-	argsTokenPath             string
-	argsBeforeExecCommand     string
-)
+var argsRoot rootCmdArgs
 
 const commandName = "credentials-broker"
 
+const (
+	defaultCacheExpirationMargin = time.Minute
+	defaultRefreshMargin         = time.Minute
+	beforeExecBackoff            = time.Second
+	defaultTokenURLField         = "token"
+)
+
+const (
+	beforeExecWhenAlways   = "always"
+	beforeExecWhenMissing  = "missing"
+	beforeExecWhenExpiring = "expiring"
+)
+
+const (
+	permissionsStrict = "strict"
+	permissionsWarn   = "warn"
+	permissionsOff    = "off"
+)
+
+var (
+	defaultCacheDir     = filepath.Join(homedir.HomeDir(), ".kube", "cache", "credentials-broker")
+	defaultOIDCTokenDir = filepath.Join(homedir.HomeDir(), ".kube", "credentials-broker", "oidc")
+)
+
 var rootCmd = &cobra.Command{
 	Use:     "credentials-broker",
 	Short:   "This tool is a kubectl plugin that supports updating credentials with kube-apiserver.",
 	Long:    "This tool is a kubectl plugin that supports updating credentials with kube-apiserver. via client-go credentials pluigin. There is nothing even to run alone.",
 	Version: Version,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		r, err := newRootCmdRunner(&rootCmdArgs{
-			clientCertificatePath: argsClientCertificatePath,
-			clientKeyPath:         argsClientKeyPath,
-			tokenPath:             argsTokenPath,
-			beforeExecCommand:     argsBeforeExecCommand,
-		})
+		r, err := newRootCmdRunner(&argsRoot)
 		if err != nil {
 			return err
 		}
@@ -61,35 +86,428 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&argsClientCertificatePath, "client-certificate-path", "", "", "PEM-encoded client certificate file path. Can contain CA certificate. If this flag is specified, --client-key-path is also required. (optional)")
-	rootCmd.Flags().StringVarP(&argsClientKeyPath, "client-key-path", "", "", "PEM-encoded client key file path. (optional)")
-	rootCmd.Flags().StringVarP(&argsTokenPath, "token-path", "", "", "Token file path. (optional)")
-	rootCmd.Flags().StringVarP(&argsBeforeExecCommand, "before-exec-command", "", "", "A command line to run before responding to the credential plugin. For example, it can be used to update certificate and token files. (optional)")
+	addRootCmdArgsFlags(rootCmd.Flags(), &argsRoot)
+}
+
+func addRootCmdArgsFlags(flags *pflag.FlagSet, args *rootCmdArgs) {
+	flags.StringVarP(&args.clientCertificatePath, "client-certificate-path", "", "", "PEM-encoded client certificate file path. Can contain CA certificate. If this flag is specified, --client-key-path is also required. (optional)")
+	flags.StringVarP(&args.clientCertificateEnv, "client-certificate-env", "", "", "Environment variable name containing PEM-encoded client certificate. Alternative to --client-certificate-path. (optional)")
+	flags.StringVarP(&args.clientKeyPath, "client-key-path", "", "", "PEM-encoded client key file path. (optional)")
+	flags.StringVarP(&args.clientKeyEnv, "client-key-env", "", "", "Environment variable name containing PEM-encoded client key. Alternative to --client-key-path. (optional)")
+	flags.StringVarP(&args.clientKeyPassphraseEnv, "client-key-passphrase-env", "", "", "Environment variable name containing the passphrase of the encrypted client key. (optional)")
+	flags.StringVarP(&args.clientKeyPassphraseCommand, "client-key-passphrase-command", "", "", "A command line whose stdout is used as the passphrase of the encrypted client key. Without these flags, the passphrase is prompted if kubectl allows the plugin to interact with the user. (optional)")
+	flags.StringVarP(&args.clientPKCS12Path, "client-pkcs12-path", "", "", "PKCS#12 (PFX) file path containing the client certificate, the intermediate certificates and the key. Alternative to --client-certificate-path and --client-key-path. (optional)")
+	flags.StringVarP(&args.clientPKCS12PassphraseEnv, "client-pkcs12-passphrase-env", "", "", "Environment variable name containing the passphrase of --client-pkcs12-path. (optional)")
+	flags.StringVarP(&args.clientPKCS12PassphraseCommand, "client-pkcs12-passphrase-command", "", "", "A command line whose stdout is used as the passphrase of --client-pkcs12-path. Without these flags, the passphrase is prompted if kubectl allows the plugin to interact with the user. (optional)")
+	flags.StringVarP(&args.clientBundlePath, "client-bundle-path", "", "", "PEM file path containing the client certificate, the intermediate certificates and the key. Alternative to --client-certificate-path and --client-key-path. (optional)")
+	flags.StringVarP(&args.tokenPath, "token-path", "", "", "Token file path. (optional)")
+	flags.StringVarP(&args.tokenEnv, "token-env", "", "", "Environment variable name containing the token. Alternative to --token-path. (optional)")
+	flags.StringVarP(&args.tokenCommand, "token-command", "", "", "A command line whose stdout is used as the token. Alternative to --token-path. (optional)")
+	flags.DurationVarP(&args.commandTimeout, "command-timeout", "", 0, "Timeout for --token-command, --client-key-passphrase-command and --client-pkcs12-passphrase-command. The command and its child processes are killed on timeout, and can not prompt on the terminal. 0 means no timeout. (optional)")
+	flags.StringVarP(&args.tokenURL, "token-url", "", "", "An HTTP(S) endpoint that returns the token in a JSON response. Alternative to --token-path. (optional)")
+	flags.StringVarP(&args.tokenURLMethod, "token-url-method", "", http.MethodGet, "HTTP method for --token-url. GET or POST. (optional)")
+	flags.StringArrayVarP(&args.tokenURLHeaders, "token-url-header", "", []string{}, "HTTP header for --token-url in the form of 'Name: value'. Can be specified multiple times. (optional)")
+	flags.StringVarP(&args.tokenURLBody, "token-url-body", "", "", "HTTP request body for --token-url. (optional)")
+	flags.StringVarP(&args.tokenURLCAPath, "token-url-ca-path", "", "", "PEM-encoded CA bundle file path to verify --token-url. The system roots are used by default. (optional)")
+	flags.StringVarP(&args.tokenURLClientCertificatePath, "token-url-client-certificate-path", "", "", "PEM-encoded client certificate file path to authenticate to --token-url. (optional)")
+	flags.StringVarP(&args.tokenURLClientKeyPath, "token-url-client-key-path", "", "", "PEM-encoded client key file path to authenticate to --token-url. (optional)")
+	flags.StringVarP(&args.tokenURLField, "token-url-field", "", defaultTokenURLField, "Dot-separated path to the token in the JSON response of --token-url. e.g. 'status.token' (optional)")
+	flags.StringVarP(&args.tokenURLExpirationField, "token-url-expiration-field", "", "", "Dot-separated path to the expiration (RFC 3339 or UNIX time) in the JSON response of --token-url. (optional)")
+	flags.StringVarP(&args.tokenURLExpiresInField, "token-url-expires-in-field", "", "", "Dot-separated path to the lifetime in seconds in the JSON response of --token-url. e.g. 'expires_in' (optional)")
+	addOIDCFlags(flags, args)
+	flags.StringVarP(&args.tokenRequestServiceAccount, "token-request-service-account", "", "", "ServiceAccount to mint a token for with the TokenRequest API of the bootstrap cluster. Alternative to --token-path. (optional)")
+	flags.StringVarP(&args.tokenRequestKubeconfig, "token-request-kubeconfig", "", "", "kubeconfig file path of the bootstrap cluster for --token-request-service-account. $KUBECONFIG or ~/.kube/config if omitted. (optional)")
+	flags.StringVarP(&args.tokenRequestContext, "token-request-context", "", "", "kubeconfig context of the bootstrap cluster for --token-request-service-account. The current context if omitted. Its user must not authenticate with this plugin. (optional)")
+	flags.StringVarP(&args.tokenRequestNamespace, "token-request-namespace", "", "", "Namespace of --token-request-service-account. The namespace of the context is used by default. (optional)")
+	flags.StringArrayVarP(&args.tokenRequestAudiences, "token-request-audience", "", []string{}, "Audience of the token minted for --token-request-service-account. Can be specified multiple times. The apiserver's audience is used by default. (optional)")
+	flags.DurationVarP(&args.tokenRequestDuration, "token-request-duration", "", 0, "Lifetime of the token minted for --token-request-service-account. 10m or more. If omitted, the apiserver decides the lifetime. (optional)")
+	flags.StringVarP(&args.csrSignerName, "csr-signer-name", "", "", "signerName of the CertificateSigningRequest to issue the client certificate with the bootstrap cluster. The generated key and the issued certificate are written to --client-key-path and --client-certificate-path, and reused until the certificate expires within --refresh-margin. (optional)")
+	flags.StringVarP(&args.csrKubeconfig, "csr-kubeconfig", "", "", "kubeconfig file path of the bootstrap cluster for --csr-signer-name. $KUBECONFIG or ~/.kube/config if omitted. (optional)")
+	flags.StringVarP(&args.csrContext, "csr-context", "", "", "kubeconfig context of the bootstrap cluster for --csr-signer-name. The current context if omitted. Its user must not authenticate with this plugin. (optional)")
+	flags.StringVarP(&args.csrUsername, "csr-username", "", "", "Username (CN) of the client certificate issued with --csr-signer-name. (optional)")
+	flags.StringArrayVarP(&args.csrGroups, "csr-group", "", []string{}, "Group (O) of the client certificate issued with --csr-signer-name. Can be specified multiple times. (optional)")
+	flags.DurationVarP(&args.csrDuration, "csr-duration", "", 0, "Lifetime of the client certificate issued with --csr-signer-name. 10m or more. If omitted, the signer decides the lifetime. (optional)")
+	flags.StringVarP(&args.certRenewURL, "cert-renew-url", "", "", "Endpoint to renew the client certificate, authenticating with the current certificate and key. When the certificate in --client-certificate-path expires within --refresh-margin, it is renewed and both --client-certificate-path and --client-key-path are replaced. (optional)")
+	flags.StringVarP(&args.certRenewCAPath, "cert-renew-ca-path", "", "", "PEM-encoded CA bundle file path to verify --cert-renew-url. The system roots are used by default. (optional)")
+	flags.StringArrayVarP(&args.customSources, "source", "", []string{}, "Additional credential source in the form of 'name:key1=value1,key2=value2'. Can be specified multiple times. e.g. 'file:slot=token,path=/path/to/token' (optional)")
+	flags.StringVarP(&args.beforeExecCommand, "before-exec-command", "", "", "A command line to run before responding to the credential plugin. For example, it can be used to update certificate and token files. (optional)")
+	flags.BoolVarP(&args.beforeExecShell, "before-exec-shell", "", false, "Run --before-exec-command via '/bin/sh -c' to use pipelines and redirects. Not supported on Windows. (Default: false)")
+	flags.DurationVarP(&args.beforeExecTimeout, "before-exec-timeout", "", 0, "Timeout for each run of --before-exec-command. The command and its child processes are killed on timeout. 0 means no timeout. (optional)")
+	flags.IntVarP(&args.beforeExecRetries, "before-exec-retries", "", 0, "Number of retries when --before-exec-command fails, with exponential backoff starting at 1s. (optional)")
+	flags.StringVarP(&args.beforeExecWhen, "before-exec-when", "", beforeExecWhenAlways, fmt.Sprintf("When to run --before-exec-command. '%s': every time, '%s': when a credential file does not exist, '%s': when a credential file is missing or expires within --refresh-margin. (optional)", beforeExecWhenAlways, beforeExecWhenMissing, beforeExecWhenExpiring))
+	flags.DurationVarP(&args.refreshMargin, "refresh-margin", "", defaultRefreshMargin, fmt.Sprintf("Credentials expiring within this duration are refreshed with --before-exec-when=%s, --csr-signer-name and --cert-renew-url. (optional)", beforeExecWhenExpiring))
+	flags.BoolVarP(&args.allowExpired, "allow-expired", "", false, "Return expired or not yet valid client certificates and tokens instead of failing. Intended for debugging. (Default: false)")
+	flags.StringVarP(&args.permissions, "permissions", "", permissionsStrict, fmt.Sprintf("Check that key and token files are not accessible by the group or others, and their directories are not writable by them. '%s': fail, '%s': print a warning, '%s': do not check. Not checked on Windows. (optional)", permissionsStrict, permissionsWarn, permissionsOff))
+	flags.StringVarP(&args.cacheDir, "cache-dir", "", defaultCacheDir, "Directory to cache credentials until they expire. Credentials without an expiration are not cached. (optional)")
+	flags.BoolVarP(&args.noCache, "no-cache", "", false, "Do not use the credentials cache. --before-exec-command runs every time. (Default: false)")
+	flags.DurationVarP(&args.cacheExpirationMargin, "cache-expiration-margin", "", defaultCacheExpirationMargin, "Cached credentials expiring within this duration are refreshed. (optional)")
+}
+
+func addOIDCFlags(flags *pflag.FlagSet, args *rootCmdArgs) {
+	flags.StringVarP(&args.oidcIssuerURL, "oidc-issuer-url", "", "", "OIDC issuer URL. The ID token is obtained with the stored refresh token. Alternative to --token-path. (optional)")
+	flags.StringVarP(&args.oidcClientID, "oidc-client-id", "", "", "OIDC client ID. Required with --oidc-issuer-url. (optional)")
+	flags.StringVarP(&args.oidcClientSecret, "oidc-client-secret", "", "", "OIDC client secret. (optional)")
+	flags.StringArrayVarP(&args.oidcExtraScopes, "oidc-extra-scope", "", []string{}, "Scope to request in addition to 'openid'. Can be specified multiple times. (optional)")
+	flags.StringVarP(&args.oidcRefreshTokenPath, "oidc-refresh-token-path", "", "", fmt.Sprintf("File path to store the OIDC refresh token. The rotated refresh token is written back to it. (Default: a file per issuer and client ID in %s)", defaultOIDCTokenDir))
+	flags.StringVarP(&args.oidcCAPath, "oidc-ca-path", "", "", "PEM-encoded CA bundle file path to verify --oidc-issuer-url. The system roots are used by default. (optional)")
 }
 
 type rootCmdRunner struct {
-	args *rootCmdArgs
-	cred credentials.Credential
+	args     *rootCmdArgs
+	cred     credentials.Credential
+	execInfo *credentials.ExecInfo
 }
 
 type rootCmdArgs struct {
-	clientCertificatePath string
-	clientKeyPath         string
-	tokenPath             string
-	beforeExecCommand     string
+	clientCertificatePath         string
+	clientCertificateEnv          string
+	clientKeyPath                 string
+	clientKeyEnv                  string
+	clientKeyPassphraseEnv        string
+	clientKeyPassphraseCommand    string
+	clientPKCS12Path              string
+	clientPKCS12PassphraseEnv     string
+	clientPKCS12PassphraseCommand string
+	clientBundlePath              string
+	tokenPath                     string
+	tokenEnv                      string
+	tokenCommand                  string
+	commandTimeout                time.Duration
+	tokenURL                      string
+	tokenURLMethod                string
+	tokenURLHeaders               []string
+	tokenURLBody                  string
+	tokenURLCAPath                string
+	tokenURLClientCertificatePath string
+	tokenURLClientKeyPath         string
+	tokenURLField                 string
+	tokenURLExpirationField       string
+	tokenURLExpiresInField        string
+	oidcIssuerURL                 string
+	oidcClientID                  string
+	oidcClientSecret              string
+	oidcExtraScopes               []string
+	oidcRefreshTokenPath          string
+	oidcCAPath                    string
+	tokenRequestServiceAccount    string
+	tokenRequestKubeconfig        string
+	tokenRequestContext           string
+	tokenRequestNamespace         string
+	tokenRequestAudiences         []string
+	tokenRequestDuration          time.Duration
+	csrSignerName                 string
+	csrKubeconfig                 string
+	csrContext                    string
+	csrUsername                   string
+	csrGroups                     []string
+	csrDuration                   time.Duration
+	certRenewURL                  string
+	certRenewCAPath               string
+	customSources                 []string
+	beforeExecCommand             string
+	beforeExecShell               bool
+	beforeExecTimeout             time.Duration
+	beforeExecRetries             int
+	beforeExecWhen                string
+	refreshMargin                 time.Duration
+	allowExpired                  bool
+	permissions                   string
+	cacheDir                      string
+	noCache                       bool
+	cacheExpirationMargin         time.Duration
+}
+
+func (args *rootCmdArgs) cacheKey() string {
+	return cache.Key(fmt.Sprintf("%#v", *args))
+}
+
+// lockPaths returns a lock file per credential file, so processes sharing any of the files are serialized
+// regardless of their other flags. The paths are sorted to always acquire the locks in the same order.
+func (args *rootCmdArgs) lockPaths() []string {
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	if len(args.cacheDir) > 0 {
+		for _, p := range []string{args.clientCertificatePath, args.clientKeyPath, args.clientPKCS12Path, args.clientBundlePath, args.tokenPath} {
+			if len(p) == 0 {
+				continue
+			}
+			if abs, err := filepath.Abs(p); err == nil {
+				p = abs
+			}
+			add(filepath.Join(args.cacheDir, cache.Key(p)+".lock"))
+		}
+	}
+	if len(args.oidcIssuerURL) > 0 {
+		add(refreshTokenLockPath(args.oidcSource()))
+	}
+
+	if len(paths) == 0 && len(args.cacheDir) > 0 {
+		return []string{filepath.Join(args.cacheDir, args.cacheKey()+".lock")}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// refreshTokenLockPath is next to the refresh token, because the login command does not know --cache-dir.
+func refreshTokenLockPath(s *sources.OIDC) string {
+	return s.RefreshTokenPath + ".lock"
 }
 
 func (args *rootCmdArgs) validate() error {
+	clientCertificateSources := countNonEmpty(args.clientCertificatePath, args.clientCertificateEnv, args.clientPKCS12Path, args.clientBundlePath)
+	clientKeySources := countNonEmpty(args.clientKeyPath, args.clientKeyEnv, args.clientPKCS12Path, args.clientBundlePath)
+	tokenSources := countNonEmpty(args.tokenPath, args.tokenEnv, args.tokenCommand, args.tokenURL, args.oidcIssuerURL, args.tokenRequestServiceAccount)
+
 	switch {
-	case args.clientCertificatePath == "" && args.clientKeyPath == "" && args.tokenPath == "":
+	case clientCertificateSources == 0 && clientKeySources == 0 && tokenSources == 0 && len(args.customSources) == 0:
 		return errors.New("requires either certificate token")
-	case (args.clientCertificatePath != "" && args.clientKeyPath == "") || args.clientCertificatePath == "" && args.clientKeyPath != "":
-		return fmt.Errorf("both client-certificate-path and client-key-path must be provided")
+	case clientCertificateSources > 1:
+		return errors.New("only one of client-certificate-path, client-certificate-env, client-pkcs12-path and client-bundle-path can be specified")
+	case clientKeySources > 1:
+		return errors.New("only one of client-key-path, client-key-env, client-pkcs12-path and client-bundle-path can be specified")
+	case tokenSources > 1:
+		return errors.New("only one of token-path, token-env, token-command, token-url, oidc-issuer-url and token-request-service-account can be specified")
+	case clientCertificateSources != clientKeySources:
+		return errors.New("both client certificate (client-certificate-path or client-certificate-env) and client key (client-key-path or client-key-env) must be provided")
+	}
+
+	if len(args.csrSignerName) > 0 && len(args.certRenewURL) > 0 {
+		return errors.New("only one of csr-signer-name and cert-renew-url can be specified")
+	}
+	if len(args.csrSignerName) > 0 && (len(args.clientCertificatePath) == 0 || len(args.clientKeyPath) == 0) {
+		return errors.New("csr-signer-name requires client-certificate-path and client-key-path to write the issued certificate and key")
+	}
+	if len(args.certRenewURL) > 0 && (len(args.clientCertificatePath) == 0 || len(args.clientKeyPath) == 0) {
+		return errors.New("cert-renew-url requires client-certificate-path and client-key-path to renew and replace")
+	}
+
+	if len(args.clientKeyPassphraseEnv) > 0 && len(args.clientKeyPassphraseCommand) > 0 {
+		return errors.New("only one of client-key-passphrase-env and client-key-passphrase-command can be specified")
+	}
+	if len(args.clientKeyPassphraseCommand) > 0 {
+		if _, _, err := command.Split(args.clientKeyPassphraseCommand); err != nil {
+			return fmt.Errorf("invalid client-key-passphrase-command: %w", err)
+		}
+	}
+	if len(args.clientPKCS12PassphraseEnv) > 0 && len(args.clientPKCS12PassphraseCommand) > 0 {
+		return errors.New("only one of client-pkcs12-passphrase-env and client-pkcs12-passphrase-command can be specified")
+	}
+	if len(args.clientPKCS12PassphraseCommand) > 0 {
+		if _, _, err := command.Split(args.clientPKCS12PassphraseCommand); err != nil {
+			return fmt.Errorf("invalid client-pkcs12-passphrase-command: %w", err)
+		}
+	}
+
+	if len(args.tokenCommand) > 0 {
+		if _, _, err := command.Split(args.tokenCommand); err != nil {
+			return fmt.Errorf("invalid token-command: %w", err)
+		}
+	}
+
+	if _, err := args.credentialSources(false); err != nil {
+		return err
+	}
+
+	if args.commandTimeout < 0 {
+		return errors.New("command-timeout must be 0 or more")
+	}
+	if args.beforeExecRetries < 0 {
+		return errors.New("before-exec-retries must be 0 or more")
+	}
+
+	switch args.beforeExecWhen {
+	case "", beforeExecWhenAlways, beforeExecWhenMissing, beforeExecWhenExpiring:
+	default:
+		return fmt.Errorf("before-exec-when must be one of %s, %s, %s", beforeExecWhenAlways, beforeExecWhenMissing, beforeExecWhenExpiring)
+	}
+	// Only the files are inspected, so the command would never run.
+	if len(args.beforeExecCommand) > 0 && (args.beforeExecWhen == beforeExecWhenMissing || args.beforeExecWhen == beforeExecWhenExpiring) &&
+		countNonEmpty(args.clientCertificatePath, args.clientKeyPath, args.clientPKCS12Path, args.clientBundlePath, args.tokenPath) == 0 {
+		return fmt.Errorf("before-exec-when=%s requires one of client-certificate-path, client-key-path, client-pkcs12-path, client-bundle-path and token-path", args.beforeExecWhen)
+	}
+
+	switch args.permissions {
+	case "", permissionsStrict, permissionsWarn, permissionsOff:
+	default:
+		return fmt.Errorf("permissions must be one of %s, %s, %s", permissionsStrict, permissionsWarn, permissionsOff)
+	}
+
+	if args.beforeExecShell && runtime.GOOS == "windows" {
+		return errors.New("before-exec-shell is not supported on windows")
+	}
+	if len(args.beforeExecCommand) > 0 && !args.beforeExecShell {
+		if _, _, err := command.Split(args.beforeExecCommand); err != nil {
+			return fmt.Errorf("invalid before-exec-command: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// credentialSources returns the sources of the credentials. Passphrases are prompted only if interactive.
+func (args *rootCmdArgs) credentialSources(interactive bool) ([]sources.Source, error) {
+	srcs := []sources.Source{}
+
+	if len(args.csrSignerName) > 0 {
+		s := &sources.CSR{
+			Kubeconfig:      args.csrKubeconfig,
+			Context:         args.csrContext,
+			SignerName:      args.csrSignerName,
+			Username:        args.csrUsername,
+			Groups:          args.csrGroups,
+			Duration:        args.csrDuration,
+			CertificatePath: args.clientCertificatePath,
+			KeyPath:         args.clientKeyPath,
+			RenewBefore:     args.refreshMargin,
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("csr: %w", err)
+		}
+		srcs = append(srcs, s)
+	} else if len(args.certRenewURL) > 0 {
+		srcs = append(srcs, &sources.Renew{
+			URL:             args.certRenewURL,
+			CertificatePath: args.clientCertificatePath,
+			KeyPath:         args.clientKeyPath,
+			CAPath:          args.certRenewCAPath,
+			RenewBefore:     args.refreshMargin,
+		})
+	} else if len(args.clientCertificatePath) > 0 {
+		srcs = append(srcs, &sources.File{Slot: sources.ClientCertificate, Path: args.clientCertificatePath})
+	}
+	if len(args.clientCertificateEnv) > 0 {
+		srcs = append(srcs, &sources.Env{Slot: sources.ClientCertificate, Name: args.clientCertificateEnv})
+	}
+	if len(args.clientKeyPath) > 0 && len(args.csrSignerName) == 0 && len(args.certRenewURL) == 0 {
+		srcs = append(srcs, &sources.File{Slot: sources.ClientKey, Path: args.clientKeyPath})
+	}
+	if len(args.clientKeyEnv) > 0 {
+		srcs = append(srcs, &sources.Env{Slot: sources.ClientKey, Name: args.clientKeyEnv})
+	}
+	if len(args.clientPKCS12Path) > 0 {
+		srcs = append(srcs, &sources.PKCS12{Path: args.clientPKCS12Path, Passphrase: args.clientPKCS12PassphraseSource(interactive).read})
+	}
+	if len(args.clientBundlePath) > 0 {
+		srcs = append(srcs, &sources.Bundle{Path: args.clientBundlePath})
+	}
+	if len(args.tokenPath) > 0 {
+		srcs = append(srcs, &sources.File{Slot: sources.Token, Path: args.tokenPath})
+	}
+	if len(args.tokenEnv) > 0 {
+		srcs = append(srcs, &sources.Env{Slot: sources.Token, Name: args.tokenEnv})
+	}
+	if len(args.tokenCommand) > 0 {
+		srcs = append(srcs, &sources.Command{Slot: sources.Token, Cmdline: args.tokenCommand, Timeout: args.commandTimeout})
+	}
+	if len(args.tokenURL) > 0 {
+		s, err := args.tokenURLSource()
+		if err != nil {
+			return nil, fmt.Errorf("token-url: %w", err)
+		}
+		srcs = append(srcs, s)
+	}
+	if len(args.oidcIssuerURL) > 0 {
+		s := args.oidcSource()
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+		srcs = append(srcs, s)
+	}
+	if len(args.tokenRequestServiceAccount) > 0 {
+		s := &sources.TokenRequest{
+			Kubeconfig:     args.tokenRequestKubeconfig,
+			Context:        args.tokenRequestContext,
+			Namespace:      args.tokenRequestNamespace,
+			ServiceAccount: args.tokenRequestServiceAccount,
+			Audiences:      args.tokenRequestAudiences,
+			Duration:       args.tokenRequestDuration,
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("token-request: %w", err)
+		}
+		srcs = append(srcs, s)
+	}
+
+	for _, spec := range args.customSources {
+		name, params, err := sources.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		s, err := sources.New(name, params)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		srcs = append(srcs, s)
+	}
+
+	return srcs, nil
+}
+
+func (args *rootCmdArgs) tokenURLSource() (*sources.HTTP, error) {
+	headers := map[string]string{}
+	for _, h := range args.tokenURLHeaders {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, fmt.Errorf("invalid header %q, must be 'Name: value'", h)
+		}
+		headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	s := &sources.HTTP{
+		Slot:                  sources.Token,
+		URL:                   args.tokenURL,
+		Method:                args.tokenURLMethod,
+		Headers:               headers,
+		Body:                  args.tokenURLBody,
+		CAPath:                args.tokenURLCAPath,
+		ClientCertificatePath: args.tokenURLClientCertificatePath,
+		ClientKeyPath:         args.tokenURLClientKeyPath,
+		Field:                 args.tokenURLField,
+		ExpirationField:       args.tokenURLExpirationField,
+		ExpiresInField:        args.tokenURLExpiresInField,
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (args *rootCmdArgs) oidcSource() *sources.OIDC {
+	refreshTokenPath := args.oidcRefreshTokenPath
+	if len(refreshTokenPath) == 0 {
+		refreshTokenPath = filepath.Join(defaultOIDCTokenDir, cache.Key(args.oidcIssuerURL+"\n"+args.oidcClientID))
+	}
+
+	return &sources.OIDC{
+		IssuerURL:        args.oidcIssuerURL,
+		ClientID:         args.oidcClientID,
+		ClientSecret:     args.oidcClientSecret,
+		Scopes:           args.oidcExtraScopes,
+		RefreshTokenPath: refreshTokenPath,
+		CAPath:           args.oidcCAPath,
+	}
+}
+
+func countNonEmpty(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if len(v) > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
 func newRootCmdRunner(args *rootCmdArgs) (*rootCmdRunner, error) {
 	if err := args.validate(); err != nil {
 		return nil, err
@@ -99,35 +517,103 @@ func newRootCmdRunner(args *rootCmdArgs) (*rootCmdRunner, error) {
 		args: args,
 	}
 
-	execAPIVersion, err := kubeconfig.New().ReadCurrentUserExecVersion()
+	execInfo, err := readExecInfo()
 	if err != nil {
 		return nil, err
 	}
+	r.execInfo = execInfo
 
-	switch execAPIVersion {
-	case (&credentials.V1Beta1{}).APIVersionString():
-		r.cred = &credentials.V1Beta1{}
-	case (&credentials.V1Alpha1{}).APIVersionString():
-		r.cred = &credentials.V1Alpha1{}
-	default:
-		return nil, fmt.Errorf("unsupported client authentication API version: %s", execAPIVersion)
+	cred, err := credentials.New(execInfo.APIVersion)
+	if err != nil {
+		return nil, err
 	}
+	r.cred = cred
 
 	return r, nil
 }
 
+// readExecInfo prefers the ExecCredential passed by client-go, because the plugin may be
+// invoked for a user other than the one of the current-context (e.g. kubectl --context).
+func readExecInfo() (*credentials.ExecInfo, error) {
+	if data := os.Getenv(credentials.ExecInfoEnv); len(data) > 0 {
+		execInfo, err := credentials.ParseExecInfo(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", credentials.ExecInfoEnv, err)
+		}
+		return execInfo, nil
+	}
+
+	execAPIVersion, err := kubeconfig.New().ReadCurrentUserExecVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials.ExecInfo{APIVersion: execAPIVersion}, nil
+}
+
 func (r *rootCmdRunner) run() ([]byte, error) {
-	if len(r.args.beforeExecCommand) > 0 {
-		if err := execCommand(r.args.beforeExecCommand); err != nil {
-			return nil, err
+	c := r.cache()
+	if opt := r.readCache(c); opt != nil {
+		return r.cached(opt)
+	}
+
+	// Only one process refreshes credentials, the others wait for it and use the result.
+	if paths := r.args.lockPaths(); len(paths) > 0 {
+		for _, path := range paths {
+			l, err := lock.Acquire(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to acquire lock: %s\n", err)
+				continue
+			}
+			defer l.Release()
+		}
+
+		if opt := r.readCache(c); opt != nil {
+			return r.cached(opt)
 		}
 	}
 
-	opt, err := makeCredentialOptions(r.args)
+	if len(r.args.beforeExecCommand) > 0 && needsBeforeExec(r.args, time.Now()) {
+		if _, err := r.beforeExecCommand().Run(context.Background()); err != nil {
+			return nil, fmt.Errorf("before-exec-command: %w", err)
+		}
+	}
+
+	opt, encrypted, err := r.credentialOptions()
 	if err != nil {
 		return nil, err
 	}
 
+	// A certificate and a key rotated by another process may be read in the middle of the rotation,
+	// so the before-exec command is given one more chance to fix them up.
+	if err := validateKeyPair(opt); err != nil {
+		if len(r.args.beforeExecCommand) == 0 {
+			return nil, err
+		}
+		if _, err := r.beforeExecCommand().Run(context.Background()); err != nil {
+			return nil, fmt.Errorf("before-exec-command: %w", err)
+		}
+		opt, encrypted, err = r.credentialOptions()
+		if err != nil {
+			return nil, err
+		}
+		if err := validateKeyPair(opt); err != nil {
+			return nil, err
+		}
+	}
+
+	if !r.args.allowExpired {
+		if err := opt.CheckValidity(time.Now()); err != nil {
+			return nil, fmt.Errorf("%w, check that the credentials are renewed (e.g. by --before-exec-command), or use --allow-expired to return them anyway", err)
+		}
+	}
+
+	if c != nil && !encrypted {
+		if err := c.Set(r.args.cacheKey(), opt); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write credentials cache: %s\n", err)
+		}
+	}
+
 	buf, err := r.cred.ToJSON(opt)
 	if err != nil {
 		return nil, err
@@ -136,51 +622,203 @@ func (r *rootCmdRunner) run() ([]byte, error) {
 	return buf, nil
 }
 
-func execCommand(cmdline string) error {
-	args := strings.Fields(cmdline)
-	err := exec.Command(args[0], args[1:]...).Run()
+// credentialOptions reads the credentials from the sources, and reports whether the client key has been decrypted.
+// The decrypted key must not be cached, otherwise it would be stored unencrypted.
+func (r *rootCmdRunner) credentialOptions() (*credentials.CredentialOption, bool, error) {
+	if err := r.args.checkPermissions(); err != nil {
+		return nil, false, err
+	}
+
+	opt, err := makeCredentialOptions(r.args, r.execInfo.Interactive)
+	if errors.Is(err, sources.ErrLoginRequired) && len(r.args.oidcIssuerURL) > 0 {
+		// kubectl sets spec.interactive to false when stdin is not available to the plugin (e.g. piped),
+		// so the user would never see the verification URL.
+		if !r.execInfo.Interactive {
+			return nil, false, fmt.Errorf("%w, run 'kubectl %s login' with the same --oidc-* flags", err, commandName)
+		}
+		if err := r.args.oidcSource().Login(context.Background(), os.Stderr); err != nil {
+			return nil, false, fmt.Errorf("oidc: login failed: %w", err)
+		}
+		opt, err = makeCredentialOptions(r.args, r.execInfo.Interactive)
+	}
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	return nil
+	encrypted := credentials.IsEncryptedPrivateKey(opt.ClientKeyData)
+	if encrypted {
+		passphrase, err := r.args.clientKeyPassphraseSource(r.execInfo.Interactive).read()
+		if err != nil {
+			return nil, false, err
+		}
+		opt.ClientKeyData, err = credentials.DecryptPrivateKey(opt.ClientKeyData, passphrase)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decrypt client key: %w", err)
+		}
+	}
+
+	return opt, encrypted, nil
 }
 
-func makeCredentialOptions(args *rootCmdArgs) (*credentials.CredentialOption, error) {
-	opts := &credentials.CredentialOption{}
+// privateFiles returns the files containing a private key or a token.
+// The files are taken from the sources, so that those of --source and the default OIDC refresh token are included.
+func (args *rootCmdArgs) privateFiles() []string {
+	// An invalid source is reported when the credentials are fetched.
+	srcs, err := args.credentialSources(false)
+	if err != nil {
+		return nil
+	}
+
+	return sources.PrivateFiles(srcs)
+}
 
-	if len(args.clientCertificatePath) > 0 {
-		buf, err := ioutil.ReadFile(args.clientCertificatePath)
-		if err != nil {
-			return nil, err
-		}
-		opts.ClientCertificateData = string(buf)
+func (args *rootCmdArgs) checkPermissions() error {
+	if args.permissions == permissionsOff {
+		return nil
 	}
 
-	if len(args.clientKeyPath) > 0 {
-		buf, err := ioutil.ReadFile(args.clientKeyPath)
-		if err != nil {
-			return nil, err
+	for _, path := range args.privateFiles() {
+		if err := fileutil.CheckPrivate(path); err != nil {
+			if args.permissions == permissionsWarn {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+				continue
+			}
+			return fmt.Errorf("%w, or use --permissions=%s", err, permissionsWarn)
 		}
-		opts.ClientKeyData = string(buf)
 	}
 
-	if len(args.tokenPath) > 0 {
-		buf, err := ioutil.ReadFile(args.tokenPath)
+	return nil
+}
+
+func validateKeyPair(opt *credentials.CredentialOption) error {
+	if len(opt.ClientCertificateData) == 0 || len(opt.ClientKeyData) == 0 {
+		return nil
+	}
+
+	return credentials.ValidateKeyPair(opt.ClientCertificateData, opt.ClientKeyData)
+}
+
+func needsBeforeExec(args *rootCmdArgs, now time.Time) bool {
+	switch args.beforeExecWhen {
+	case beforeExecWhenMissing:
+		for _, path := range []string{args.clientCertificatePath, args.clientKeyPath, args.clientPKCS12Path, args.clientBundlePath, args.tokenPath} {
+			if len(path) == 0 {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				return true
+			}
+		}
+		return false
+	case beforeExecWhenExpiring:
+		// Only the files are inspected. Fetching the other sources has side effects, e.g. running a command,
+		// rotating the OIDC refresh token or issuing a certificate.
+		srcs := []sources.Source{}
+		for _, f := range []sources.File{
+			{Slot: sources.ClientCertificate, Path: args.clientCertificatePath},
+			{Slot: sources.ClientKey, Path: args.clientKeyPath},
+			{Slot: sources.Token, Path: args.tokenPath},
+		} {
+			if len(f.Path) > 0 {
+				f := f
+				srcs = append(srcs, &f)
+			}
+		}
+		if len(args.clientBundlePath) > 0 {
+			srcs = append(srcs, &sources.Bundle{Path: args.clientBundlePath})
+		}
+		if len(args.clientPKCS12Path) > 0 {
+			if _, err := os.Stat(args.clientPKCS12Path); err != nil {
+				return true
+			}
+		}
+		opt, err := sources.FetchAll(context.Background(), srcs)
 		if err != nil {
-			return nil, err
+			return true
 		}
-		opts.Token = chop(string(buf))
+		return opt.ExpirationTimestamp != nil && !now.Add(args.refreshMargin).Before(*opt.ExpirationTimestamp)
+	default:
+		return true
 	}
+}
 
-	return opts, nil
+func (r *rootCmdRunner) beforeExecCommand() *command.Command {
+	return &command.Command{
+		Cmdline: r.args.beforeExecCommand,
+		Shell:   r.args.beforeExecShell,
+		Timeout: r.args.beforeExecTimeout,
+		Retries: r.args.beforeExecRetries,
+		Backoff: beforeExecBackoff,
+		Stderr:  os.Stderr,
+	}
+}
+
+// cached serves the cached credentials, unless their files have been made accessible to others since.
+func (r *rootCmdRunner) cached(opt *credentials.CredentialOption) ([]byte, error) {
+	if err := r.args.checkPermissions(); err != nil {
+		return nil, err
+	}
+
+	return r.cred.ToJSON(opt)
 }
 
-func chop(s string) string {
-	s = strings.TrimRight(s, "\n")
-	if strings.HasSuffix(s, "\r") {
-		s = strings.TrimRight(s, "\r")
+func (r *rootCmdRunner) readCache(c *cache.Cache) *credentials.CredentialOption {
+	if c == nil {
+		return nil
 	}
 
-	return s
+	opt, err := c.Get(r.args.cacheKey(), r.args.cacheMargin())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to read credentials cache: %s\n", err)
+		return nil
+	}
+
+	return opt
+}
+
+// cacheMargin does not let the cache hide credentials which are to be refreshed within the refresh margin.
+func (args *rootCmdArgs) cacheMargin() time.Duration {
+	refreshes := args.beforeExecWhen == beforeExecWhenExpiring || len(args.csrSignerName) > 0 || len(args.certRenewURL) > 0
+	if refreshes && args.refreshMargin > args.cacheExpirationMargin {
+		return args.refreshMargin
+	}
+
+	return args.cacheExpirationMargin
+}
+
+func (r *rootCmdRunner) cache() *cache.Cache {
+	if r.args.noCache || len(r.args.cacheDir) == 0 {
+		return nil
+	}
+
+	// An invalid source is reported when the credentials are fetched.
+	srcs, err := r.args.credentialSources(r.execInfo.Interactive)
+	if err != nil || !sources.Cacheable(srcs) {
+		return nil
+	}
+
+	return cache.New(r.args.cacheDir)
+}
+
+func makeCredentialOptions(args *rootCmdArgs, interactive bool) (*credentials.CredentialOption, error) {
+	srcs, err := args.credentialSources(interactive)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := sources.FetchAll(context.Background(), srcs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(opts.ClientCertificateData) == 0 && len(opts.ClientKeyData) == 0 && len(opts.Token) == 0:
+		return nil, errors.New("no credentials are provided by the sources")
+	case len(opts.ClientCertificateData) > 0 && len(opts.ClientKeyData) == 0:
+		return nil, errors.New("client certificate is provided without client key")
+	case len(opts.ClientCertificateData) == 0 && len(opts.ClientKeyData) > 0:
+		return nil, errors.New("client key is provided without client certificate")
+	}
+
+	return opts, nil
 }
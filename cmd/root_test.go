@@ -1,20 +1,58 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/takumakume/kubectl-credentials-broker/cache"
 	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 func Test_rootCmdArgs_validate(t *testing.T) {
 	type fields struct {
-		clientCertificatePath string
-		clientKeyPath         string
-		tokenPath             string
-		beforeExecCommand     string
+		clientCertificatePath      string
+		clientCertificateEnv       string
+		clientKeyPath              string
+		clientKeyEnv               string
+		tokenPath                  string
+		tokenEnv                   string
+		tokenCommand               string
+		tokenURL                   string
+		tokenURLHeaders            []string
+		oidcIssuerURL              string
+		oidcClientID               string
+		tokenRequestServiceAccount string
+		tokenRequestDuration       time.Duration
+		csrSignerName              string
+		csrUsername                string
+		certRenewURL               string
+		clientKeyPassphraseEnv     string
+		clientKeyPassphraseCommand string
+		clientPKCS12Path           string
+		clientBundlePath           string
+		commandTimeout             time.Duration
+		permissions                string
+		customSources              []string
+		beforeExecCommand          string
+		beforeExecShell            bool
+		beforeExecRetries          int
+		beforeExecWhen             string
 	}
 	tests := []struct {
 		name    string
@@ -68,6 +106,360 @@ func Test_rootCmdArgs_validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "certificate from env and key from file",
+			fields: fields{
+				clientCertificateEnv: "CLIENT_CERTIFICATE",
+				clientKeyPath:        "/path/to/tls.key",
+			},
+		},
+		{
+			name: "certificate, key and token from env",
+			fields: fields{
+				clientCertificateEnv: "CLIENT_CERTIFICATE",
+				clientKeyEnv:         "CLIENT_KEY",
+				tokenEnv:             "TOKEN",
+			},
+		},
+		{
+			name: "certificate from env without key",
+			fields: fields{
+				clientCertificateEnv: "CLIENT_CERTIFICATE",
+			},
+			wantErr: true,
+		},
+		{
+			name: "certificate from both file and env",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientCertificateEnv:  "CLIENT_CERTIFICATE",
+				clientKeyPath:         "/path/to/tls.key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "key from both file and env",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientKeyPath:         "/path/to/tls.key",
+				clientKeyEnv:          "CLIENT_KEY",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token from both env and command",
+			fields: fields{
+				tokenEnv:     "TOKEN",
+				tokenCommand: "/path/to/print-token.sh",
+			},
+			wantErr: true,
+		},
+		{
+			name: "custom source only",
+			fields: fields{
+				customSources: []string{"env:slot=token,name=TOKEN"},
+			},
+		},
+		{
+			name: "unknown custom source",
+			fields: fields{
+				customSources: []string{"unknown:slot=token"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid custom source params",
+			fields: fields{
+				customSources: []string{"env:slot=password,name=PASSWORD"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "token-command only",
+			fields: fields{
+				tokenCommand: "/path/to/print-token.sh --name 'my cluster'",
+			},
+		},
+		{
+			name: "token-path and token-command",
+			fields: fields{
+				tokenPath:    "/path/to/token",
+				tokenCommand: "/path/to/print-token.sh",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid token-command",
+			fields: fields{
+				tokenCommand: "/path/to/print-token.sh 'my cluster",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token-url only",
+			fields: fields{
+				tokenURL:        "https://example.com/token",
+				tokenURLHeaders: []string{"Authorization: Bearer xxx"},
+			},
+		},
+		{
+			name: "token-path and token-url",
+			fields: fields{
+				tokenPath: "/path/to/token",
+				tokenURL:  "https://example.com/token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid token-url-header",
+			fields: fields{
+				tokenURL:        "https://example.com/token",
+				tokenURLHeaders: []string{"Authorization"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "oidc only",
+			fields: fields{
+				oidcIssuerURL: "https://issuer.example.com",
+				oidcClientID:  "kubernetes",
+			},
+		},
+		{
+			name: "oidc without client id",
+			fields: fields{
+				oidcIssuerURL: "https://issuer.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token-path and oidc",
+			fields: fields{
+				tokenPath:     "/path/to/token",
+				oidcIssuerURL: "https://issuer.example.com",
+				oidcClientID:  "kubernetes",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token-request only",
+			fields: fields{
+				tokenRequestServiceAccount: "developer",
+				tokenRequestDuration:       time.Hour,
+			},
+		},
+		{
+			name: "too short token-request-duration",
+			fields: fields{
+				tokenRequestServiceAccount: "developer",
+				tokenRequestDuration:       time.Minute,
+			},
+			wantErr: true,
+		},
+		{
+			name: "token-path and token-request",
+			fields: fields{
+				tokenPath:                  "/path/to/token",
+				tokenRequestServiceAccount: "developer",
+			},
+			wantErr: true,
+		},
+		{
+			name: "csr",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientKeyPath:         "/path/to/tls.key",
+				csrSignerName:         "kubernetes.io/kube-apiserver-client",
+				csrUsername:           "user1",
+			},
+		},
+		{
+			name: "csr without username",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientKeyPath:         "/path/to/tls.key",
+				csrSignerName:         "kubernetes.io/kube-apiserver-client",
+			},
+			wantErr: true,
+		},
+		{
+			name: "csr without paths",
+			fields: fields{
+				csrSignerName: "kubernetes.io/kube-apiserver-client",
+				csrUsername:   "user1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "cert-renew-url",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientKeyPath:         "/path/to/tls.key",
+				certRenewURL:          "https://ca.example.com/renew",
+			},
+		},
+		{
+			name: "cert-renew-url with env",
+			fields: fields{
+				clientCertificateEnv: "CLIENT_CERTIFICATE",
+				clientKeyEnv:         "CLIENT_KEY",
+				certRenewURL:         "https://ca.example.com/renew",
+			},
+			wantErr: true,
+		},
+		{
+			name: "csr and cert-renew-url",
+			fields: fields{
+				clientCertificatePath: "/path/to/tls.crt",
+				clientKeyPath:         "/path/to/tls.key",
+				csrSignerName:         "kubernetes.io/kube-apiserver-client",
+				csrUsername:           "user1",
+				certRenewURL:          "https://ca.example.com/renew",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client-key-passphrase-env and client-key-passphrase-command",
+			fields: fields{
+				clientCertificatePath:      "/path/to/tls.crt",
+				clientKeyPath:              "/path/to/tls.key",
+				clientKeyPassphraseEnv:     "PASSPHRASE",
+				clientKeyPassphraseCommand: "/path/to/print-passphrase.sh",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid client-key-passphrase-command",
+			fields: fields{
+				clientCertificatePath:      "/path/to/tls.crt",
+				clientKeyPath:              "/path/to/tls.key",
+				clientKeyPassphraseCommand: "/path/to/print-passphrase.sh 'my key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client-pkcs12-path",
+			fields: fields{
+				clientPKCS12Path: "/path/to/client.p12",
+				tokenPath:        "/path/to/token",
+			},
+		},
+		{
+			name: "client-pkcs12-path and client-certificate-path",
+			fields: fields{
+				clientPKCS12Path:      "/path/to/client.p12",
+				clientCertificatePath: "/path/to/tls.crt",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client-pkcs12-path and client-key-path",
+			fields: fields{
+				clientPKCS12Path: "/path/to/client.p12",
+				clientKeyPath:    "/path/to/tls.key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client-bundle-path",
+			fields: fields{
+				clientBundlePath: "/path/to/client.pem",
+			},
+		},
+		{
+			name: "client-bundle-path and client-pkcs12-path",
+			fields: fields{
+				clientBundlePath: "/path/to/client.pem",
+				clientPKCS12Path: "/path/to/client.p12",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client-bundle-path and client-key-env",
+			fields: fields{
+				clientBundlePath: "/path/to/client.pem",
+				clientKeyEnv:     "CLIENT_KEY",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative command-timeout",
+			fields: fields{
+				tokenCommand:   "/path/to/print-token.sh",
+				commandTimeout: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid permissions",
+			fields: fields{
+				tokenPath:   "/path/to/token",
+				permissions: "ignore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid before-exec-command",
+			fields: fields{
+				tokenPath:         "/path/to/token",
+				beforeExecCommand: "/path/to/script.sh 'my cluster",
+			},
+			wantErr: true,
+		},
+		{
+			name: "before-exec-command with shell",
+			fields: fields{
+				tokenPath:         "/path/to/token",
+				beforeExecCommand: "/path/to/script.sh | tee /tmp/log 2>&1",
+				beforeExecShell:   true,
+			},
+			// There is no /bin/sh.
+			wantErr: runtime.GOOS == "windows",
+		},
+		{
+			name: "negative before-exec-retries",
+			fields: fields{
+				tokenPath:         "/path/to/token",
+				beforeExecCommand: "/path/to/script.sh",
+				beforeExecRetries: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "before-exec-when",
+			fields: fields{
+				tokenPath:         "/path/to/token",
+				beforeExecCommand: "/path/to/script.sh",
+				beforeExecWhen:    "expiring",
+			},
+		},
+		{
+			name: "before-exec-when=missing without credential files",
+			fields: fields{
+				tokenEnv:          "TOKEN",
+				beforeExecCommand: "/path/to/script.sh",
+				beforeExecWhen:    "missing",
+			},
+			wantErr: true,
+		},
+		{
+			name: "before-exec-when=expiring without credential files",
+			fields: fields{
+				tokenCommand:      "print-token",
+				beforeExecCommand: "/path/to/script.sh",
+				beforeExecWhen:    "expiring",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid before-exec-when",
+			fields: fields{
+				tokenPath:         "/path/to/token",
+				beforeExecCommand: "/path/to/script.sh",
+				beforeExecWhen:    "sometimes",
+			},
+			wantErr: true,
+		},
 		{
 			name: "requires either certificate token",
 			fields: fields{
@@ -82,10 +474,33 @@ func Test_rootCmdArgs_validate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			args := &rootCmdArgs{
-				clientCertificatePath: tt.fields.clientCertificatePath,
-				clientKeyPath:         tt.fields.clientKeyPath,
-				tokenPath:             tt.fields.tokenPath,
-				beforeExecCommand:     tt.fields.beforeExecCommand,
+				clientCertificatePath:      tt.fields.clientCertificatePath,
+				clientCertificateEnv:       tt.fields.clientCertificateEnv,
+				clientKeyPath:              tt.fields.clientKeyPath,
+				clientKeyEnv:               tt.fields.clientKeyEnv,
+				tokenPath:                  tt.fields.tokenPath,
+				tokenEnv:                   tt.fields.tokenEnv,
+				tokenCommand:               tt.fields.tokenCommand,
+				tokenURL:                   tt.fields.tokenURL,
+				tokenURLHeaders:            tt.fields.tokenURLHeaders,
+				oidcIssuerURL:              tt.fields.oidcIssuerURL,
+				oidcClientID:               tt.fields.oidcClientID,
+				tokenRequestServiceAccount: tt.fields.tokenRequestServiceAccount,
+				tokenRequestDuration:       tt.fields.tokenRequestDuration,
+				csrSignerName:              tt.fields.csrSignerName,
+				csrUsername:                tt.fields.csrUsername,
+				certRenewURL:               tt.fields.certRenewURL,
+				clientKeyPassphraseEnv:     tt.fields.clientKeyPassphraseEnv,
+				clientKeyPassphraseCommand: tt.fields.clientKeyPassphraseCommand,
+				clientPKCS12Path:           tt.fields.clientPKCS12Path,
+				clientBundlePath:           tt.fields.clientBundlePath,
+				commandTimeout:             tt.fields.commandTimeout,
+				permissions:                tt.fields.permissions,
+				customSources:              tt.fields.customSources,
+				beforeExecCommand:          tt.fields.beforeExecCommand,
+				beforeExecShell:            tt.fields.beforeExecShell,
+				beforeExecRetries:          tt.fields.beforeExecRetries,
+				beforeExecWhen:             tt.fields.beforeExecWhen,
 			}
 			if err := args.validate(); (err != nil) != tt.wantErr {
 				t.Errorf("rootCmdArgs.validate() error = %v, wantErr %v", err, tt.wantErr)
@@ -111,6 +526,7 @@ func TestRun(t *testing.T) {
 	type args struct {
 		rootCmdArgs    rootCmdArgs
 		kubeconfigData string
+		execInfo       string
 	}
 	tests := []struct {
 		name    string
@@ -118,6 +534,35 @@ func TestRun(t *testing.T) {
 		want    []byte
 		wantErr bool
 	}{
+		{
+			name: "API version: client.authentication.k8s.io/v1",
+			args: args{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath: tokenFile.Name(),
+				},
+				kubeconfigData: `---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    namespace: default
+    user: user1
+  name: context1
+users:
+- name: user1
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      interactiveMode: IfAvailable`,
+			},
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false},"status":{"token":"token-from-file"}}`),
+		},
 		{
 			name: "API version: client.authentication.k8s.io/v1beta1",
 			args: args{
@@ -144,7 +589,7 @@ users:
     exec:
       apiVersion: client.authentication.k8s.io/v1beta1`,
 			},
-			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{},"status":{"token":"token-from-file"}}`),
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"token":"token-from-file"}}`),
 		},
 		{
 			name: "API version: client.authentication.k8s.io/v1alpha1",
@@ -174,28 +619,85 @@ users:
 			},
 			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1alpha1","spec":{},"status":{"token":"token-from-file"}}`),
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			kubeconfigFile, err := ioutil.TempFile("", "kube-config-")
-			defer os.Remove(kubeconfigFile.Name())
-
-			if _, err = kubeconfigFile.Write([]byte(tt.args.kubeconfigData)); err != nil {
-				t.Errorf("kubeconfigFile.Write() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Run error = %v, wantErr %v", err, tt.wantErr)
+		{
+			name: "API version from KUBERNETES_EXEC_INFO (current-context user is not a plugin user)",
+			args: args{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath: tokenFile.Name(),
+				},
+				kubeconfigData: `---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    namespace: default
+    user: user1
+  name: context1
+users:
+- name: user1
+  user:
+    token: XXXXXXXX`,
+				execInfo: `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`,
+			},
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false},"status":{"token":"token-from-file"}}`),
+		},
+		{
+			name: "invalid KUBERNETES_EXEC_INFO",
+			args: args{
+				rootCmdArgs: rootCmdArgs{
+					tokenPath: tokenFile.Name(),
+				},
+				kubeconfigData: `---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    namespace: default
+    user: user1
+  name: context1
+users:
+- name: user1
+  user:
+    token: XXXXXXXX`,
+				execInfo: `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v2"}`,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeconfigFile, err := ioutil.TempFile("", "kube-config-")
+			defer os.Remove(kubeconfigFile.Name())
+
+			if _, err = kubeconfigFile.Write([]byte(tt.args.kubeconfigData)); err != nil {
+				t.Errorf("kubeconfigFile.Write() error = %v", err)
 				return
 			}
+
 			os.Setenv("KUBECONFIG", kubeconfigFile.Name())
+			os.Setenv("KUBERNETES_EXEC_INFO", tt.args.execInfo)
+			defer os.Unsetenv("KUBERNETES_EXEC_INFO")
 
 			runner, err := newRootCmdRunner(&tt.args.rootCmdArgs)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Run newRunner() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
 			got, err := runner.run()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Run newRunner().run() error = %v, wantErr %v", err, tt.wantErr)
@@ -216,14 +718,17 @@ func Test_makeCredentialOptions(t *testing.T) {
 	}
 	defer os.Remove(testDir)
 
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+
 	certFile, err := ioutil.TempFile(testDir, "tls.crt")
-	if _, err = certFile.Write([]byte("client-certificate-from-file")); err != nil {
+	if _, err = certFile.Write([]byte(cert.CertPEM)); err != nil {
 		t.Errorf("ioutil.Write() error = %v", err)
 		return
 	}
 
 	keyFile, err := ioutil.TempFile(testDir, "tls.key")
-	if _, err = keyFile.Write([]byte("client-key-from-file")); err != nil {
+	if _, err = keyFile.Write([]byte(cert.KeyPEM)); err != nil {
 		t.Errorf("ioutil.Write() error = %v", err)
 		return
 	}
@@ -240,6 +745,21 @@ func Test_makeCredentialOptions(t *testing.T) {
 		return
 	}
 
+	jwt := testutil.GenerateJWT(t, map[string]interface{}{"sub": "user1", "exp": now.Add(30 * time.Minute).Unix()})
+	jwtFile, err := ioutil.TempFile(testDir, "jwt")
+	if _, err = jwtFile.Write([]byte(jwt + "\n")); err != nil {
+		t.Errorf("ioutil.Write() error = %v", err)
+		return
+	}
+	jwtExpirationTimestamp := now.Add(30 * time.Minute).UTC()
+
+	os.Setenv("TEST_CLIENT_CERTIFICATE", cert.CertPEM)
+	defer os.Unsetenv("TEST_CLIENT_CERTIFICATE")
+	os.Setenv("TEST_CLIENT_KEY", cert.KeyPEM)
+	defer os.Unsetenv("TEST_CLIENT_KEY")
+	os.Setenv("TEST_TOKEN", "token-from-env\n")
+	defer os.Unsetenv("TEST_TOKEN")
+
 	type args struct {
 		args *rootCmdArgs
 	}
@@ -259,9 +779,10 @@ func Test_makeCredentialOptions(t *testing.T) {
 				},
 			},
 			want: &credentials.CredentialOption{
-				ClientCertificateData: "client-certificate-from-file",
-				ClientKeyData:         "client-key-from-file",
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
 				Token:                 "token-from-file",
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
 			},
 		},
 		{
@@ -273,8 +794,9 @@ func Test_makeCredentialOptions(t *testing.T) {
 				},
 			},
 			want: &credentials.CredentialOption{
-				ClientCertificateData: "client-certificate-from-file",
-				ClientKeyData:         "client-key-from-file",
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
 			},
 		},
 		{
@@ -288,10 +810,142 @@ func Test_makeCredentialOptions(t *testing.T) {
 				Token: "token-from-file",
 			},
 		},
+		{
+			name: "args JWT token",
+			args: args{
+				args: &rootCmdArgs{
+					tokenPath: jwtFile.Name(),
+				},
+			},
+			want: &credentials.CredentialOption{
+				Token:               jwt,
+				ExpirationTimestamp: &jwtExpirationTimestamp,
+			},
+		},
+		{
+			name: "args certificate/key and JWT token (earliest expiration)",
+			args: args{
+				args: &rootCmdArgs{
+					clientCertificatePath: certFile.Name(),
+					clientKeyPath:         keyFile.Name(),
+					tokenPath:             jwtFile.Name(),
+				},
+			},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
+				Token:                 jwt,
+				ExpirationTimestamp:   &jwtExpirationTimestamp,
+			},
+		},
+		{
+			name: "args certificate/key and token from env",
+			args: args{
+				args: &rootCmdArgs{
+					clientCertificateEnv: "TEST_CLIENT_CERTIFICATE",
+					clientKeyEnv:         "TEST_CLIENT_KEY",
+					tokenEnv:             "TEST_TOKEN",
+				},
+			},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
+				Token:                 "token-from-env",
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
+			},
+		},
+		{
+			name: "args certificate from env and key from file",
+			args: args{
+				args: &rootCmdArgs{
+					clientCertificateEnv: "TEST_CLIENT_CERTIFICATE",
+					clientKeyPath:        keyFile.Name(),
+				},
+			},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
+			},
+		},
+		{
+			name: "args unset env",
+			args: args{
+				args: &rootCmdArgs{
+					tokenEnv: "TEST_UNSET_TOKEN",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "args custom sources",
+			args: args{
+				args: &rootCmdArgs{
+					customSources: []string{
+						"env:slot=client-certificate,name=TEST_CLIENT_CERTIFICATE",
+						"file:slot=client-key,path=" + keyFile.Name(),
+					},
+				},
+			},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: cert.CertPEM,
+				ClientKeyData:         cert.KeyPEM,
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
+			},
+		},
+		{
+			name: "args custom source conflicts",
+			args: args{
+				args: &rootCmdArgs{
+					tokenPath:     tokenFile.Name(),
+					customSources: []string{"env:slot=token,name=TEST_TOKEN"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "args custom source provides only certificate",
+			args: args{
+				args: &rootCmdArgs{
+					customSources: []string{"env:slot=client-certificate,name=TEST_CLIENT_CERTIFICATE"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "args token-command",
+			args: args{
+				args: &rootCmdArgs{
+					tokenCommand: "echo token-from-command",
+				},
+			},
+			want: &credentials.CredentialOption{
+				Token: "token-from-command",
+			},
+		},
+		{
+			name: "args failed token-command",
+			args: args{
+				args: &rootCmdArgs{
+					tokenCommand: "false",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "args invalid certificate",
+			args: args{
+				args: &rootCmdArgs{
+					clientCertificatePath: caFile.Name(),
+					clientKeyPath:         keyFile.Name(),
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := makeCredentialOptions(tt.args.args)
+			got, err := makeCredentialOptions(tt.args.args, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("makeCredentialOptions() error = %+v, wantErr %+v", err, tt.wantErr)
 				return
@@ -303,35 +957,1040 @@ func Test_makeCredentialOptions(t *testing.T) {
 	}
 }
 
-func Test_chop(t *testing.T) {
-	type args struct {
-		s string
+func TestRun_cache(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tokenPath := filepath.Join(testDir, "token")
+	cachedToken := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	refreshedToken := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(2 * time.Hour).Unix()})
+
+	tests := []struct {
+		name      string
+		noCache   bool
+		margin    time.Duration
+		wantToken string
+	}{
+		{
+			name:      "cached",
+			margin:    time.Minute,
+			wantToken: cachedToken,
+		},
+		{
+			name:      "expires within margin",
+			margin:    2 * time.Hour,
+			wantToken: refreshedToken,
+		},
+		{
+			name:      "no-cache",
+			noCache:   true,
+			margin:    time.Minute,
+			wantToken: refreshedToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+			defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+			args := &rootCmdArgs{
+				tokenPath:             tokenPath,
+				cacheDir:              filepath.Join(testDir, tt.name),
+				noCache:               tt.noCache,
+				cacheExpirationMargin: tt.margin,
+			}
+			runner, err := newRootCmdRunner(args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			if err := ioutil.WriteFile(tokenPath, []byte(cachedToken), 0600); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+			if _, err := runner.run(); err != nil {
+				t.Errorf("run() error = %v", err)
+				return
+			}
+
+			if err := ioutil.WriteFile(tokenPath, []byte(refreshedToken), 0600); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+			got, err := runner.run()
+			if err != nil {
+				t.Errorf("run() error = %v", err)
+				return
+			}
+			if !strings.Contains(string(got), tt.wantToken) {
+				t.Errorf("run() = %v, want token %v", string(got), tt.wantToken)
+			}
+		})
 	}
+}
+
+func TestRun_lock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
 	tests := []struct {
 		name string
-		args args
-		want string
+		args func(i int) *rootCmdArgs
 	}{
 		{
-			name: "remove last return code",
-			args: args{s: "string\n"},
-			want: "string",
+			name: "same flags",
+			args: func(i int) *rootCmdArgs {
+				return &rootCmdArgs{cacheExpirationMargin: time.Minute}
+			},
+		},
+		{
+			name: "different flags sharing the credential file",
+			args: func(i int) *rootCmdArgs {
+				return &rootCmdArgs{
+					beforeExecWhen:        beforeExecWhenExpiring,
+					refreshMargin:         time.Minute,
+					cacheExpirationMargin: time.Duration(i+1) * time.Minute,
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Errorf("TempDir() error = %v", err)
+				return
+			}
+			defer os.RemoveAll(testDir)
+
+			tokenPath := filepath.Join(testDir, "token")
+			countPath := filepath.Join(testDir, "count")
+			token := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+			scriptPath := filepath.Join(testDir, "refresh.sh")
+			script := fmt.Sprintf("#!/bin/sh\numask 077\necho refreshed >> %s\nsleep 0.2\necho %s > %s\n", countPath, token, tokenPath)
+			if err := ioutil.WriteFile(scriptPath, []byte(script), 0700); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+
+			os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+			defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					args := tt.args(i)
+					args.tokenPath = tokenPath
+					args.beforeExecCommand = scriptPath
+					args.cacheDir = filepath.Join(testDir, "cache")
+					runner, err := newRootCmdRunner(args)
+					if err != nil {
+						t.Errorf("newRootCmdRunner() error = %v", err)
+						return
+					}
+					got, err := runner.run()
+					if err != nil {
+						t.Errorf("run() error = %v", err)
+						return
+					}
+					if !strings.Contains(string(got), token) {
+						t.Errorf("run() = %v, want token %v", string(got), token)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			buf, err := ioutil.ReadFile(countPath)
+			if err != nil {
+				t.Errorf("ioutil.ReadFile() error = %v", err)
+				return
+			}
+			if n := strings.Count(string(buf), "refreshed"); n != 1 {
+				t.Errorf("before-exec-command ran %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func Test_lockPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		args1 *rootCmdArgs
+		args2 *rootCmdArgs
+		want  bool
+	}{
+		{
+			name:  "sharing the credential file",
+			args1: &rootCmdArgs{tokenPath: "/tmp/token", cacheDir: "/tmp/cache", cacheExpirationMargin: time.Minute},
+			args2: &rootCmdArgs{tokenPath: "/tmp/token", cacheDir: "/tmp/cache", cacheExpirationMargin: time.Hour},
+			want:  true,
+		},
+		{
+			name:  "different credential files",
+			args1: &rootCmdArgs{tokenPath: "/tmp/token1", cacheDir: "/tmp/cache"},
+			args2: &rootCmdArgs{tokenPath: "/tmp/token2", cacheDir: "/tmp/cache"},
+			want:  false,
+		},
+		{
+			name:  "sharing the default refresh token",
+			args1: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1", cacheDir: "/tmp/cache", cacheExpirationMargin: time.Minute},
+			args2: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1", cacheDir: "/tmp/cache", cacheExpirationMargin: time.Hour},
+			want:  true,
 		},
 		{
-			name: "remove only last return code",
-			args: args{s: "string1\nstring2\n"},
-			want: "string1\nstring2",
+			name:  "sharing the refresh token without cache-dir",
+			args1: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1", oidcRefreshTokenPath: "/tmp/refresh-token"},
+			args2: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1", oidcRefreshTokenPath: "/tmp/refresh-token", oidcExtraScopes: []string{"groups"}},
+			want:  true,
 		},
 		{
-			name: "remove CR",
-			args: args{s: "string\r\n"},
-			want: "string",
+			name:  "different clients",
+			args1: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1", cacheDir: "/tmp/cache"},
+			args2: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client2", cacheDir: "/tmp/cache"},
+			want:  false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := chop(tt.args.s); got != tt.want {
-				t.Errorf("chop() = %v, want %v", got, tt.want)
+			paths1, paths2 := tt.args1.lockPaths(), tt.args2.lockPaths()
+			if len(paths1) == 0 || len(paths2) == 0 {
+				t.Errorf("lockPaths() = %v, %v, want lock files", paths1, paths2)
+				return
+			}
+			if got := reflect.DeepEqual(paths1, paths2); got != tt.want {
+				t.Errorf("lockPaths() = %v, %v, want same %v", paths1, paths2, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_lockUnavailable(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tokenPath := filepath.Join(testDir, "token")
+	token := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	if err := ioutil.WriteFile(tokenPath, []byte(token), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	tests := []struct {
+		name    string
+		noCache bool
+	}{
+		{
+			name: "cache",
+		},
+		{
+			name:    "no-cache",
+			noCache: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, err := newRootCmdRunner(&rootCmdArgs{
+				tokenPath: tokenPath,
+				// The cache directory cannot be created under a regular file.
+				cacheDir:              filepath.Join(tokenPath, "cache"),
+				noCache:               tt.noCache,
+				cacheExpirationMargin: time.Minute,
+			})
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+			got, err := runner.run()
+			if err != nil {
+				t.Errorf("run() error = %v", err)
+				return
+			}
+			if !strings.Contains(string(got), token) {
+				t.Errorf("run() = %v, want token %v", string(got), token)
+			}
+		})
+	}
+}
+
+func Test_needsBeforeExec(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	certPath := filepath.Join(testDir, "tls.crt")
+	keyPath := filepath.Join(testDir, "tls.key")
+	if err := ioutil.WriteFile(certPath, []byte(cert.CertPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(keyPath, []byte(cert.KeyPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	tokenPath := filepath.Join(testDir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("token-from-file"), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	bundlePath := filepath.Join(testDir, "bundle.pem")
+	if err := ioutil.WriteFile(bundlePath, []byte(cert.CertPEM+cert.KeyPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	missingPath := filepath.Join(testDir, "missing")
+	markerPath := filepath.Join(testDir, "marker")
+
+	tests := []struct {
+		name string
+		args *rootCmdArgs
+		want bool
+	}{
+		{
+			name: "always",
+			args: &rootCmdArgs{
+				tokenPath:      tokenPath,
+				beforeExecWhen: beforeExecWhenAlways,
+			},
+			want: true,
+		},
+		{
+			name: "default is always",
+			args: &rootCmdArgs{
+				tokenPath: tokenPath,
+			},
+			want: true,
+		},
+		{
+			name: "missing: files exist",
+			args: &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         keyPath,
+				tokenPath:             tokenPath,
+				beforeExecWhen:        beforeExecWhenMissing,
+			},
+			want: false,
+		},
+		{
+			name: "missing: a file does not exist",
+			args: &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         missingPath,
+				beforeExecWhen:        beforeExecWhenMissing,
+			},
+			want: true,
+		},
+		{
+			name: "expiring: not expiring",
+			args: &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         keyPath,
+				beforeExecWhen:        beforeExecWhenExpiring,
+				refreshMargin:         time.Minute,
+			},
+			want: false,
+		},
+		{
+			name: "expiring: expires within margin",
+			args: &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         keyPath,
+				beforeExecWhen:        beforeExecWhenExpiring,
+				refreshMargin:         2 * time.Hour,
+			},
+			want: true,
+		},
+		{
+			name: "expiring: without expiration",
+			args: &rootCmdArgs{
+				tokenPath:      tokenPath,
+				beforeExecWhen: beforeExecWhenExpiring,
+				refreshMargin:  2 * time.Hour,
+			},
+			want: false,
+		},
+		{
+			name: "expiring: a file does not exist",
+			args: &rootCmdArgs{
+				tokenPath:      missingPath,
+				beforeExecWhen: beforeExecWhenExpiring,
+				refreshMargin:  time.Minute,
+			},
+			want: true,
+		},
+		{
+			name: "expiring: bundle expires within margin",
+			args: &rootCmdArgs{
+				clientBundlePath: bundlePath,
+				beforeExecWhen:   beforeExecWhenExpiring,
+				refreshMargin:    2 * time.Hour,
+			},
+			want: true,
+		},
+		{
+			name: "expiring: token command is not run",
+			args: &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         keyPath,
+				tokenCommand:          "touch " + markerPath,
+				beforeExecWhen:        beforeExecWhenExpiring,
+				refreshMargin:         time.Minute,
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsBeforeExec(tt.args, now); got != tt.want {
+				t.Errorf("needsBeforeExec() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("needsBeforeExec() ran --token-command")
+	}
+}
+
+func Test_rootCmdArgs_cacheMargin(t *testing.T) {
+	tests := []struct {
+		name string
+		args rootCmdArgs
+		want time.Duration
+	}{
+		{
+			name: "cache expiration margin",
+			args: rootCmdArgs{tokenPath: "/path/to/token", refreshMargin: time.Hour, cacheExpirationMargin: time.Minute},
+			want: time.Minute,
+		},
+		{
+			name: "refresh margin of before-exec-when=expiring",
+			args: rootCmdArgs{tokenPath: "/path/to/token", beforeExecWhen: beforeExecWhenExpiring, refreshMargin: time.Hour, cacheExpirationMargin: time.Minute},
+			want: time.Hour,
+		},
+		{
+			name: "refresh margin of cert-renew-url",
+			args: rootCmdArgs{certRenewURL: "https://ca.example.com/renew", refreshMargin: time.Hour, cacheExpirationMargin: time.Minute},
+			want: time.Hour,
+		},
+		{
+			name: "larger cache expiration margin",
+			args: rootCmdArgs{csrSignerName: "kubernetes.io/kube-apiserver-client", refreshMargin: time.Minute, cacheExpirationMargin: time.Hour},
+			want: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.args.cacheMargin(); got != tt.want {
+				t.Errorf("rootCmdArgs.cacheMargin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_encryptedClientKey(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	keyBlock, _ := pem.Decode([]byte(cert.KeyPEM))
+	encryptedBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Errorf("x509.EncryptPEMBlock() error = %v", err)
+		return
+	}
+	certPath := filepath.Join(testDir, "tls.crt")
+	keyPath := filepath.Join(testDir, "tls.key")
+	if err := ioutil.WriteFile(certPath, []byte(cert.CertPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(encryptedBlock), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	os.Setenv("TEST_CLIENT_KEY_PASSPHRASE", "passphrase")
+	defer os.Unsetenv("TEST_CLIENT_KEY_PASSPHRASE")
+
+	defaultReadPassword := readPassword
+	defer func() { readPassword = defaultReadPassword }()
+	readPassword = func(prompt string) ([]byte, error) {
+		return []byte("passphrase"), nil
+	}
+
+	tests := []struct {
+		name              string
+		passphraseEnv     string
+		passphraseCommand string
+		interactive       bool
+		wantErr           bool
+	}{
+		{
+			name:          "passphrase from env",
+			passphraseEnv: "TEST_CLIENT_KEY_PASSPHRASE",
+		},
+		{
+			name:              "passphrase from command",
+			passphraseCommand: "echo passphrase",
+		},
+		{
+			name:        "passphrase prompt",
+			interactive: true,
+		},
+		{
+			name:    "no passphrase when not interactive",
+			wantErr: true,
+		},
+		{
+			name:              "incorrect passphrase",
+			passphraseCommand: "echo incorrect",
+			wantErr:           true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && len(tt.passphraseCommand) > 0 {
+				t.Skip("echo is not an executable on Windows")
+			}
+
+			os.Setenv("KUBERNETES_EXEC_INFO", fmt.Sprintf(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":%t}}`, tt.interactive))
+			defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+			cacheDir := filepath.Join(testDir, "cache", tt.name)
+			args := &rootCmdArgs{
+				clientCertificatePath:      certPath,
+				clientKeyPath:              keyPath,
+				clientKeyPassphraseEnv:     tt.passphraseEnv,
+				clientKeyPassphraseCommand: tt.passphraseCommand,
+				cacheDir:                   cacheDir,
+				cacheExpirationMargin:      time.Minute,
+			}
+			runner, err := newRootCmdRunner(args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			got, err := runner.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			status := struct {
+				Status struct {
+					ClientKeyData string `json:"clientKeyData"`
+				} `json:"status"`
+			}{}
+			if err := json.Unmarshal(got, &status); err != nil {
+				t.Errorf("json.Unmarshal() error = %v", err)
+				return
+			}
+			if status.Status.ClientKeyData != cert.KeyPEM {
+				t.Errorf("run() clientKeyData = %v, want the decrypted key", status.Status.ClientKeyData)
+			}
+
+			if cached, _ := cache.New(cacheDir).Get(args.cacheKey(), 0); cached != nil {
+				t.Errorf("run() cached the decrypted key")
+			}
+		})
+	}
+}
+
+func TestRun_pkcs12(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	pfx, err := pkcs12.Encode(rand.Reader, cert.PrivateKey, cert.Certificate, nil, "")
+	if err != nil {
+		t.Errorf("pkcs12.Encode() error = %v", err)
+		return
+	}
+	path := filepath.Join(testDir, "client.p12")
+	if err := ioutil.WriteFile(path, pfx, 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	tests := []struct {
+		name string
+		args rootCmdArgs
+	}{
+		{
+			name: "client-pkcs12-path",
+			args: rootCmdArgs{clientPKCS12Path: path},
+		},
+		{
+			name: "pkcs12 source",
+			args: rootCmdArgs{customSources: []string{"pkcs12:path=" + path}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := filepath.Join(testDir, "cache", tt.name)
+			args := tt.args
+			args.cacheDir = cacheDir
+			args.cacheExpirationMargin = time.Minute
+			runner, err := newRootCmdRunner(&args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			got, err := runner.run()
+			if err != nil {
+				t.Errorf("run() error = %v", err)
+				return
+			}
+
+			status := struct {
+				Status struct {
+					ClientCertificateData string `json:"clientCertificateData"`
+					ClientKeyData         string `json:"clientKeyData"`
+				} `json:"status"`
+			}{}
+			if err := json.Unmarshal(got, &status); err != nil {
+				t.Errorf("json.Unmarshal() error = %v", err)
+				return
+			}
+			if status.Status.ClientCertificateData != cert.CertPEM {
+				t.Errorf("run() clientCertificateData = %v, want %v", status.Status.ClientCertificateData, cert.CertPEM)
+			}
+			if len(status.Status.ClientKeyData) == 0 {
+				t.Errorf("run() clientKeyData is empty")
+			}
+
+			if cached, _ := cache.New(cacheDir).Get(args.cacheKey(), 0); cached != nil {
+				t.Errorf("run() cached the key read from the PKCS#12 file")
+			}
+		})
+	}
+}
+
+func TestRun_keyPairMismatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	staleCert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	tests := []struct {
+		name       string
+		script     string
+		wantErr    bool
+		wantBefore int
+	}{
+		{
+			name:    "without before-exec-command",
+			wantErr: true,
+		},
+		{
+			name:       "before-exec-command fixes the key on the second run",
+			script:     "#!/bin/sh\necho ran >> %[1]s\nif [ $(wc -l < %[1]s) -ge 2 ]; then cp %[2]s %[3]s; fi\n",
+			wantBefore: 2,
+		},
+		{
+			name:       "before-exec-command does not fix the key",
+			script:     "#!/bin/sh\necho ran >> %[1]s\n",
+			wantErr:    true,
+			wantBefore: 2,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(testDir, strconv.Itoa(i))
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Errorf("os.Mkdir() error = %v", err)
+				return
+			}
+			certPath := filepath.Join(dir, "tls.crt")
+			keyPath := filepath.Join(dir, "tls.key")
+			newKeyPath := filepath.Join(dir, "tls.key.new")
+			countPath := filepath.Join(dir, "count")
+			for path, data := range map[string]string{certPath: cert.CertPEM, keyPath: staleCert.KeyPEM, newKeyPath: cert.KeyPEM} {
+				if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+					t.Errorf("ioutil.WriteFile() error = %v", err)
+					return
+				}
+			}
+
+			args := &rootCmdArgs{
+				clientCertificatePath: certPath,
+				clientKeyPath:         keyPath,
+				noCache:               true,
+			}
+			if len(tt.script) > 0 {
+				scriptPath := filepath.Join(dir, "rotate.sh")
+				if err := ioutil.WriteFile(scriptPath, []byte(fmt.Sprintf(tt.script, countPath, newKeyPath, keyPath)), 0700); err != nil {
+					t.Errorf("ioutil.WriteFile() error = %v", err)
+					return
+				}
+				args.beforeExecCommand = scriptPath
+			}
+			runner, err := newRootCmdRunner(args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			_, err = runner.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !errors.Is(err, credentials.ErrKeyPairMismatch) {
+				t.Errorf("run() error = %v, want %v", err, credentials.ErrKeyPairMismatch)
+			}
+
+			buf, _ := ioutil.ReadFile(countPath)
+			if n := strings.Count(string(buf), "ran"); n != tt.wantBefore {
+				t.Errorf("before-exec-command ran %d times, want %d", n, tt.wantBefore)
+			}
+		})
+	}
+}
+
+func TestRun_expired(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	expired := testutil.GenerateCertificate(t, "user1", now.Add(-2*time.Hour), now.Add(-time.Hour), nil)
+	future := testutil.GenerateCertificate(t, "user1", now.Add(time.Hour), now.Add(2*time.Hour), nil)
+	files := map[string]string{
+		"expired.crt":   expired.CertPEM,
+		"expired.key":   expired.KeyPEM,
+		"future.crt":    future.CertPEM,
+		"future.key":    future.KeyPEM,
+		"expired-token": testutil.GenerateJWT(t, map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}),
+		"future-token":  testutil.GenerateJWT(t, map[string]interface{}{"nbf": now.Add(time.Hour).Unix(), "exp": now.Add(2 * time.Hour).Unix()}),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(testDir, name), []byte(data), 0600); err != nil {
+			t.Errorf("ioutil.WriteFile() error = %v", err)
+			return
+		}
+	}
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	tests := []struct {
+		name    string
+		args    rootCmdArgs
+		wantErr error
+	}{
+		{
+			name:    "expired certificate",
+			args:    rootCmdArgs{clientCertificatePath: filepath.Join(testDir, "expired.crt"), clientKeyPath: filepath.Join(testDir, "expired.key")},
+			wantErr: credentials.ErrExpired,
+		},
+		{
+			name:    "not yet valid certificate",
+			args:    rootCmdArgs{clientCertificatePath: filepath.Join(testDir, "future.crt"), clientKeyPath: filepath.Join(testDir, "future.key")},
+			wantErr: credentials.ErrNotYetValid,
+		},
+		{
+			name:    "expired token",
+			args:    rootCmdArgs{tokenPath: filepath.Join(testDir, "expired-token")},
+			wantErr: credentials.ErrExpired,
+		},
+		{
+			name:    "not yet valid token",
+			args:    rootCmdArgs{tokenPath: filepath.Join(testDir, "future-token")},
+			wantErr: credentials.ErrNotYetValid,
+		},
+		{
+			name: "allow-expired",
+			args: rootCmdArgs{clientCertificatePath: filepath.Join(testDir, "expired.crt"), clientKeyPath: filepath.Join(testDir, "expired.key"), allowExpired: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			args.noCache = true
+			runner, err := newRootCmdRunner(&args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			_, err = runner.run()
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("run() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("run() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRun_permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tokenPath := filepath.Join(testDir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("token-from-file"), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	if err := os.Chmod(tokenPath, 0644); err != nil {
+		t.Errorf("os.Chmod() error = %v", err)
+		return
+	}
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	tests := []struct {
+		name        string
+		permissions string
+		wantErr     bool
+	}{
+		{
+			name:    "default",
+			wantErr: true,
+		},
+		{
+			name:        "strict",
+			permissions: permissionsStrict,
+			wantErr:     true,
+		},
+		{
+			name:        "warn",
+			permissions: permissionsWarn,
+		},
+		{
+			name:        "off",
+			permissions: permissionsOff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, err := newRootCmdRunner(&rootCmdArgs{
+				tokenPath:   tokenPath,
+				permissions: tt.permissions,
+				noCache:     true,
+			})
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			got, err := runner.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !strings.Contains(string(got), "token-from-file") {
+				t.Errorf("run() = %v, want token-from-file", string(got))
+			}
+		})
+	}
+}
+
+func Test_rootCmdArgs_privateFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		args *rootCmdArgs
+		want []string
+	}{
+		{
+			name: "flags",
+			args: &rootCmdArgs{clientCertificatePath: "/path/to/tls.crt", clientKeyPath: "/path/to/tls.key", tokenPath: "/path/to/token"},
+			want: []string{"/path/to/tls.key", "/path/to/token"},
+		},
+		{
+			name: "sources",
+			args: &rootCmdArgs{customSources: []string{
+				"file:slot=client-certificate,path=/path/to/tls.crt",
+				"file:slot=client-key,path=/path/to/tls.key",
+				"bundle:path=/path/to/bundle.pem",
+				"pkcs12:path=/path/to/client.p12",
+			}},
+			want: []string{"/path/to/tls.key", "/path/to/bundle.pem", "/path/to/client.p12"},
+		},
+		{
+			name: "default OIDC refresh token",
+			args: &rootCmdArgs{oidcIssuerURL: "https://issuer.example.com", oidcClientID: "client1"},
+			want: []string{filepath.Join(defaultOIDCTokenDir, cache.Key("https://issuer.example.com\nclient1"))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.args.privateFiles(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("privateFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_permissionsCached(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tokenPath := filepath.Join(testDir, "token")
+	token := testutil.GenerateJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	if err := ioutil.WriteFile(tokenPath, []byte(token), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+
+	args := &rootCmdArgs{
+		tokenPath:             tokenPath,
+		cacheDir:              filepath.Join(testDir, "cache"),
+		cacheExpirationMargin: time.Minute,
+	}
+	runner, err := newRootCmdRunner(args)
+	if err != nil {
+		t.Errorf("newRootCmdRunner() error = %v", err)
+		return
+	}
+	if _, err := runner.run(); err != nil {
+		t.Errorf("run() error = %v", err)
+		return
+	}
+
+	if err := os.Chmod(tokenPath, 0644); err != nil {
+		t.Errorf("os.Chmod() error = %v", err)
+		return
+	}
+	if _, err := runner.run(); err == nil {
+		t.Errorf("run() served the cached token of a file readable by others")
+	}
+}
+
+func TestRun_cacheUncacheableSources(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	aliceToken := testutil.GenerateJWT(t, map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	bobToken := testutil.GenerateJWT(t, map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	defer os.Unsetenv("KUBERNETES_EXEC_INFO")
+	defer os.Unsetenv("TEST_TOKEN")
+
+	tests := []struct {
+		name string
+		args rootCmdArgs
+	}{
+		{
+			name: "token-env",
+			args: rootCmdArgs{tokenEnv: "TEST_TOKEN"},
+		},
+		{
+			name: "env source",
+			args: rootCmdArgs{customSources: []string{"env:slot=token,name=TEST_TOKEN"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := filepath.Join(testDir, tt.name)
+			args := tt.args
+			args.cacheDir = cacheDir
+			args.cacheExpirationMargin = time.Minute
+			runner, err := newRootCmdRunner(&args)
+			if err != nil {
+				t.Errorf("newRootCmdRunner() error = %v", err)
+				return
+			}
+
+			for _, token := range []string{aliceToken, bobToken} {
+				os.Setenv("TEST_TOKEN", token)
+				got, err := runner.run()
+				if err != nil {
+					t.Errorf("run() error = %v", err)
+					return
+				}
+				if !strings.Contains(string(got), token) {
+					t.Errorf("run() = %v, want token %v", string(got), token)
+				}
+			}
+
+			if cached, _ := cache.New(cacheDir).Get(args.cacheKey(), 0); cached != nil {
+				t.Errorf("run() cached the token read from the environment variable")
 			}
 		})
 	}
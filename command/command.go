@@ -0,0 +1,251 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+)
+
+type Command struct {
+	Cmdline string
+	// Shell runs Cmdline via '/bin/sh -c'.
+	Shell bool
+	// Timeout is the timeout for each attempt. Zero means no timeout.
+	// With a timeout, the command runs in its own process group so that its children are killed on timeout,
+	// which means it can not read from the terminal.
+	Timeout time.Duration
+	// Retries is the number of retries after the first attempt fails.
+	Retries int
+	// Backoff is the wait before the first retry. It doubles on each retry.
+	Backoff time.Duration
+	// Stderr receives the stderr of the command. If nil, it is discarded.
+	Stderr io.Writer
+}
+
+// stderrTailSize is the maximum size of stderr included in the error.
+const stderrTailSize = 1024
+
+// outputGracePeriod is how long the output is read after the command exits.
+// Processes started in the background by the command may keep the pipes open much longer.
+const outputGracePeriod = 100 * time.Millisecond
+
+func Split(cmdline string) (string, []string, error) {
+	parsedCmd, err := shellquote.Split(cmdline)
+	if err != nil {
+		return "", []string{}, err
+	}
+
+	if len(parsedCmd) == 0 {
+		return "", []string{}, nil
+	} else if len(parsedCmd) == 1 {
+		return parsedCmd[0], []string{}, nil
+	} else {
+		return parsedCmd[0], parsedCmd[1:], nil
+	}
+}
+
+func (c *Command) name() string {
+	if c.Shell {
+		return c.Cmdline
+	}
+
+	name, _, err := Split(c.Cmdline)
+	if err != nil || len(name) == 0 {
+		return c.Cmdline
+	}
+
+	return name
+}
+
+func (c *Command) command() (*exec.Cmd, error) {
+	if c.Shell {
+		return exec.Command("/bin/sh", "-c", c.Cmdline), nil
+	}
+
+	name, args, err := Split(c.Cmdline)
+	if err != nil {
+		return nil, err
+	}
+	if len(name) == 0 {
+		return nil, errors.New("command is empty")
+	}
+
+	return exec.Command(name, args...), nil
+}
+
+// Run runs the command and returns its stdout.
+// The stdout is never written anywhere else, so that it does not mix with the output of the caller.
+func (c *Command) Run(ctx context.Context) ([]byte, error) {
+	backoff := c.Backoff
+	var (
+		stdout []byte
+		err    error
+	)
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		if stdout, err = c.run(ctx); err == nil {
+			return stdout, nil
+		}
+	}
+
+	if c.Retries > 0 {
+		return nil, fmt.Errorf("%w (gave up after %d attempts)", err, c.Retries+1)
+	}
+
+	return nil, err
+}
+
+func (c *Command) run(ctx context.Context) ([]byte, error) {
+	cmd, err := c.command()
+	if err != nil {
+		return nil, err
+	}
+	// Without a timeout, the command stays in the foreground process group of the caller,
+	// so that it can prompt on the terminal and receives Ctrl-C.
+	if c.Timeout > 0 {
+		setProcessGroup(cmd)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &tailBuffer{size: stderrTailSize}
+	var stderrWriter io.Writer = stderr
+	if c.Stderr != nil {
+		stderrWriter = io.MultiWriter(c.Stderr, stderr)
+	}
+	// The pipes are created here instead of by exec.Cmd, whose Wait also waits for the processes
+	// in the background which inherited them.
+	stdoutPipe, err := newOutputPipe(stdout)
+	if err != nil {
+		return nil, err
+	}
+	stderrPipe, err := newOutputPipe(stderrWriter)
+	if err != nil {
+		stdoutPipe.close()
+		return nil, err
+	}
+	cmd.Stdout = stdoutPipe.w
+	cmd.Stderr = stderrPipe.w
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err = cmd.Start()
+	stdoutPipe.start()
+	stderrPipe.start()
+	if err != nil {
+		stdoutPipe.wait()
+		stderrPipe.wait()
+		return nil, fmt.Errorf("%s: %w", c.name(), err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		stdoutPipe.wait()
+		stderrPipe.wait()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%s failed after %s: %w%s", c.name(), time.Since(start).Round(time.Millisecond), err, stderr.summary())
+		}
+		return stdout.Bytes(), nil
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s timed out after %s%s", c.name(), time.Since(start).Round(time.Millisecond), stderr.summary())
+		}
+		return nil, fmt.Errorf("%s was canceled after %s: %w", c.name(), time.Since(start).Round(time.Millisecond), ctx.Err())
+	}
+}
+
+// outputPipe copies the output of the command to dst.
+type outputPipe struct {
+	r, w   *os.File
+	dst    io.Writer
+	copied chan struct{}
+}
+
+func newOutputPipe(dst io.Writer) (*outputPipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &outputPipe{r: r, w: w, dst: dst, copied: make(chan struct{})}, nil
+}
+
+// start copies the output in the background. The write end is closed, because the command has its own copy.
+func (p *outputPipe) start() {
+	p.w.Close()
+	go func() {
+		io.Copy(p.dst, p.r)
+		close(p.copied)
+	}()
+}
+
+// wait waits until the output is copied after the command exits. If processes in the background
+// still hold the write end after outputGracePeriod, the rest of the output is discarded.
+func (p *outputPipe) wait() {
+	select {
+	case <-p.copied:
+	case <-time.After(outputGracePeriod):
+		if err := p.r.SetReadDeadline(time.Now()); err != nil {
+			p.r.Close()
+		}
+		<-p.copied
+	}
+	p.r.Close()
+}
+
+func (p *outputPipe) close() {
+	p.r.Close()
+	p.w.Close()
+}
+
+// tailBuffer keeps the last size bytes written.
+type tailBuffer struct {
+	size int
+	buf  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.size {
+		b.buf = b.buf[len(b.buf)-b.size:]
+	}
+
+	return len(p), nil
+}
+
+func (b *tailBuffer) summary() string {
+	s := strings.TrimSpace(string(b.buf))
+	if len(s) == 0 {
+		return ""
+	}
+
+	return ": stderr: " + s
+}
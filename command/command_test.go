@@ -0,0 +1,342 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	type args struct {
+		commandline string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantCmd  string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name: "command with args",
+			args: args{
+				commandline: "/cmd -args1 -args2",
+			},
+			wantCmd:  "/cmd",
+			wantArgs: []string{"-args1", "-args2"},
+		},
+		{
+			name: "command only",
+			args: args{
+				commandline: "/cmd",
+			},
+			wantCmd:  "/cmd",
+			wantArgs: []string{},
+		},
+		{
+			name: "empty",
+			args: args{
+				commandline: "",
+			},
+			wantCmd:  "",
+			wantArgs: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, err := Split(tt.args.commandline)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Split() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.wantCmd {
+				t.Errorf("Split() got = %v, want %v", got, tt.wantCmd)
+			}
+			if !reflect.DeepEqual(got1, tt.wantArgs) {
+				t.Errorf("Split() got1 = %+v, want %+v", got1, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	countPath := filepath.Join(testDir, "count")
+
+	tests := []struct {
+		name        string
+		command     Command
+		wantErr     string
+		wantAttempt int
+		maxElapsed  time.Duration
+	}{
+		{
+			name: "ok",
+			command: Command{
+				Cmdline: "true",
+			},
+		},
+		{
+			name: "timeout kills process group",
+			command: Command{
+				Cmdline: "sleep 10; sleep 10",
+				Shell:   true,
+				Timeout: 100 * time.Millisecond,
+			},
+			wantErr:    "timed out after",
+			maxElapsed: 5 * time.Second,
+		},
+		{
+			name: "retry until success",
+			command: Command{
+				Cmdline: "echo x >> " + countPath + "; test $(wc -l < " + countPath + ") -ge 3",
+				Shell:   true,
+				Retries: 3,
+				Backoff: 10 * time.Millisecond,
+			},
+			wantAttempt: 3,
+		},
+		{
+			name: "give up",
+			command: Command{
+				Cmdline: "echo x >> " + countPath + "; false",
+				Shell:   true,
+				Retries: 2,
+				Backoff: 10 * time.Millisecond,
+			},
+			wantErr:     "gave up after 3 attempts",
+			wantAttempt: 3,
+		},
+		{
+			name: "error names the command",
+			command: Command{
+				Cmdline: "false --flag",
+			},
+			wantErr: "false failed after",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(countPath)
+
+			start := time.Now()
+			_, err := tt.command.Run(context.Background())
+			if (err != nil) != (tt.wantErr != "") {
+				t.Errorf("Command.Run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Command.Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.maxElapsed > 0 && time.Since(start) > tt.maxElapsed {
+				t.Errorf("Command.Run() elapsed = %v, want less than %v", time.Since(start), tt.maxElapsed)
+			}
+			if tt.wantAttempt > 0 {
+				buf, err := ioutil.ReadFile(countPath)
+				if err != nil {
+					t.Errorf("ioutil.ReadFile() error = %v", err)
+					return
+				}
+				if n := strings.Count(string(buf), "x"); n != tt.wantAttempt {
+					t.Errorf("Command.Run() attempts = %d, want %d", n, tt.wantAttempt)
+				}
+			}
+		})
+	}
+}
+
+func TestCommand_Run_cmdline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	outPath := filepath.Join(testDir, "out")
+	scriptPath := filepath.Join(testDir, "script.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte("#!/bin/sh\nprintf '%s|' \"$@\" > "+outPath+"\n"), 0700); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	type args struct {
+		cmdline string
+		shell   bool
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "quoted args",
+			args: args{
+				cmdline: scriptPath + " --name 'my cluster' \"a b\"",
+			},
+			want: "--name|my cluster|a b|",
+		},
+		{
+			name: "shell",
+			args: args{
+				cmdline: "echo 'my cluster' | " + scriptPath + " --name",
+				shell:   true,
+			},
+			want: "--name|",
+		},
+		{
+			name: "shell redirect",
+			args: args{
+				cmdline: "echo -n 'my cluster' > " + outPath,
+				shell:   true,
+			},
+			want: "my cluster",
+		},
+		{
+			name: "failure",
+			args: args{
+				cmdline: "false",
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty",
+			args: args{
+				cmdline: "",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(outPath)
+
+			c := &Command{
+				Cmdline: tt.args.cmdline,
+				Shell:   tt.args.shell,
+			}
+			if _, err := c.Run(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Command.Run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			buf, err := ioutil.ReadFile(outPath)
+			if err != nil {
+				t.Errorf("ioutil.ReadFile() error = %v", err)
+				return
+			}
+			if string(buf) != tt.want {
+				t.Errorf("Command.Run() output = %v, want %v", string(buf), tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Run_output(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
+	tests := []struct {
+		name       string
+		cmdline    string
+		wantStdout string
+		wantStderr string
+		wantErr    string
+	}{
+		{
+			name:       "stdout is captured and stderr is forwarded",
+			cmdline:    "echo out; echo err >&2",
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+		},
+		{
+			name:       "error includes stderr",
+			cmdline:    "echo out; echo 'certificate request denied' >&2; exit 1",
+			wantStderr: "certificate request denied\n",
+			wantErr:    "exit status 1: stderr: certificate request denied",
+		},
+		{
+			name:       "error includes only the tail of stderr",
+			cmdline:    "head -c 2000 /dev/zero | tr '\\0' a >&2; echo last >&2; exit 1",
+			wantStderr: strings.Repeat("a", 2000) + "last\n",
+			wantErr:    ": stderr: " + strings.Repeat("a", 1019) + "last",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &strings.Builder{}
+			c := &Command{
+				Cmdline: tt.cmdline,
+				Shell:   true,
+				Stderr:  stderr,
+			}
+			got, err := c.Run(context.Background())
+			if (err != nil) != (tt.wantErr != "") {
+				t.Errorf("Command.Run() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !strings.HasSuffix(err.Error(), tt.wantErr) {
+				t.Errorf("Command.Run() error = %v, want suffix %v", err, tt.wantErr)
+			}
+			if string(got) != tt.wantStdout {
+				t.Errorf("Command.Run() stdout = %q, want %q", string(got), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("Command.Run() stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestCommand_Run_backgroundChild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+
+	stderr := &strings.Builder{}
+	c := &Command{
+		Cmdline: "sleep 5 & echo out; echo err >&2",
+		Shell:   true,
+		Stderr:  stderr,
+	}
+	start := time.Now()
+	got, err := c.Run(context.Background())
+	if err != nil {
+		t.Errorf("Command.Run() error = %v", err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Command.Run() waited %s for the background child", elapsed)
+	}
+	if string(got) != "out\n" {
+		t.Errorf("Command.Run() stdout = %q, want %q", string(got), "out\n")
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("Command.Run() stderr = %q, want %q", stderr.String(), "err\n")
+	}
+}
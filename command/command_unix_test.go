@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommand_Run_processGroup(t *testing.T) {
+	pgid := strconv.Itoa(syscall.Getpgrp())
+
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		wantSamePgid bool
+	}{
+		{
+			name:         "without timeout",
+			wantSamePgid: true,
+		},
+		{
+			name:         "with timeout",
+			timeout:      10 * time.Second,
+			wantSamePgid: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Command{
+				Cmdline: "ps -o pgid= -p $$",
+				Shell:   true,
+				Timeout: tt.timeout,
+			}
+			got, err := c.Run(context.Background())
+			if err != nil {
+				t.Errorf("Command.Run() error = %v", err)
+				return
+			}
+			if same := strings.TrimSpace(string(got)) == pgid; same != tt.wantSamePgid {
+				t.Errorf("Command.Run() process group = %s, caller = %s, want same %v", strings.TrimSpace(string(got)), pgid, tt.wantSamePgid)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package credentials
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+func ParseCertificates(data string) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+
+	return certs, nil
+}
+
+func LeafCertificate(data string) (*x509.Certificate, error) {
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return cert, nil
+		}
+	}
+
+	return certs[0], nil
+}
+
+func CertificateExpirationTimestamp(data string) (*time.Time, error) {
+	cert, err := LeafCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := cert.NotAfter
+	return &notAfter, nil
+}
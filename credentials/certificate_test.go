@@ -0,0 +1,65 @@
+package credentials
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestCertificateExpirationTimestamp(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	leaf := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	type args struct {
+		data string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "leaf only",
+			args: args{data: leaf.CertPEM},
+			want: now.Add(time.Hour),
+		},
+		{
+			name: "leaf with CA certificate",
+			args: args{data: leaf.CertPEM + ca.CertPEM},
+			want: now.Add(time.Hour),
+		},
+		{
+			name: "CA certificate before leaf",
+			args: args{data: ca.CertPEM + leaf.CertPEM},
+			want: now.Add(time.Hour),
+		},
+		{
+			name:    "not PEM",
+			args:    args{data: "client-certificate"},
+			wantErr: true,
+		},
+		{
+			name:    "private key only",
+			args:    args{data: leaf.KeyPEM},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CertificateExpirationTimestamp(tt.args.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CertificateExpirationTimestamp() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("CertificateExpirationTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
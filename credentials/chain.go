@@ -0,0 +1,79 @@
+package credentials
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+)
+
+// OrderCertificates orders the certificates from the leaf to the root, following the issuers.
+// The leaf is the certificate which does not issue any other certificate. Certificates which are not
+// in the chain of the leaf are appended in the original order.
+func OrderCertificates(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	leaves := []*x509.Certificate{}
+	for _, cert := range certs {
+		if !issuesAny(cert, certs) {
+			leaves = append(leaves, cert)
+		}
+	}
+	if len(leaves) > 1 {
+		nonCA := []*x509.Certificate{}
+		for _, cert := range leaves {
+			if !cert.IsCA {
+				nonCA = append(nonCA, cert)
+			}
+		}
+		leaves = nonCA
+	}
+	switch len(leaves) {
+	case 0:
+		return nil, errors.New("leaf certificate not found")
+	case 1:
+	default:
+		return nil, errors.New("more than one leaf certificate found")
+	}
+
+	ordered := []*x509.Certificate{leaves[0]}
+	used := map[*x509.Certificate]bool{leaves[0]: true}
+	for current := leaves[0]; !isSelfSigned(current); {
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !used[cert] && bytes.Equal(cert.RawSubject, current.RawIssuer) {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		ordered = append(ordered, issuer)
+		used[issuer] = true
+		current = issuer
+	}
+
+	for _, cert := range certs {
+		if !used[cert] {
+			ordered = append(ordered, cert)
+		}
+	}
+
+	return ordered, nil
+}
+
+func issuesAny(issuer *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		if cert != issuer && bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
@@ -0,0 +1,92 @@
+package credentials
+
+import (
+	"crypto/x509"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestOrderCertificates(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	root := testutil.GenerateCertificate(t, "root", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	intermediate := testutil.GenerateCertificate(t, "intermediate", now.Add(-time.Hour), now.Add(time.Hour), root)
+	leaf := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), intermediate)
+	other := testutil.GenerateCertificate(t, "other", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	otherLeaf := testutil.GenerateCertificate(t, "other-leaf", now.Add(-time.Hour), now.Add(time.Hour), other)
+
+	tests := []struct {
+		name    string
+		certs   []*testutil.Certificate
+		want    []*testutil.Certificate
+		wantErr bool
+	}{
+		{
+			name:  "already ordered",
+			certs: []*testutil.Certificate{leaf, intermediate, root},
+			want:  []*testutil.Certificate{leaf, intermediate, root},
+		},
+		{
+			name:  "reversed",
+			certs: []*testutil.Certificate{root, intermediate, leaf},
+			want:  []*testutil.Certificate{leaf, intermediate, root},
+		},
+		{
+			name:  "shuffled without root",
+			certs: []*testutil.Certificate{intermediate, leaf},
+			want:  []*testutil.Certificate{leaf, intermediate},
+		},
+		{
+			name:  "unrelated CA is appended",
+			certs: []*testutil.Certificate{other, root, leaf, intermediate},
+			want:  []*testutil.Certificate{leaf, intermediate, root, other},
+		},
+		{
+			name:  "self-signed leaf",
+			certs: []*testutil.Certificate{root},
+			want:  []*testutil.Certificate{root},
+		},
+		{
+			name:    "more than one leaf",
+			certs:   []*testutil.Certificate{leaf, intermediate, otherLeaf},
+			wantErr: true,
+		},
+		{
+			name:    "no certificate",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OrderCertificates(x509Certificates(tt.certs))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OrderCertificates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := x509Certificates(tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("OrderCertificates() = %v, want %v", subjects(got), subjects(want))
+			}
+		})
+	}
+}
+
+func x509Certificates(certs []*testutil.Certificate) []*x509.Certificate {
+	ret := []*x509.Certificate{}
+	for _, cert := range certs {
+		ret = append(ret, cert.Certificate)
+	}
+	return ret
+}
+
+func subjects(certs []*x509.Certificate) []string {
+	ret := []string{}
+	for _, cert := range certs {
+		ret = append(ret, cert.Subject.CommonName)
+	}
+	return ret
+}
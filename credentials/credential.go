@@ -1,6 +1,18 @@
 package credentials
 
+import "fmt"
+
 type Credential interface {
 	APIVersionString() string
 	ToJSON(opts *CredentialOption) ([]byte, error)
 }
+
+func New(apiVersion string) (Credential, error) {
+	for _, c := range []Credential{&V1{}, &V1Beta1{}, &V1Alpha1{}} {
+		if c.APIVersionString() == apiVersion {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported client authentication API version: %s", apiVersion)
+}
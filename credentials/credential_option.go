@@ -1,7 +1,48 @@
 package credentials
 
+import (
+	"errors"
+	"time"
+)
+
 type CredentialOption struct {
 	ClientCertificateData string
 	ClientKeyData         string
 	Token                 string
+	ExpirationTimestamp   *time.Time
+}
+
+// UpdateExpirationTimestamp keeps the earliest expiration timestamp.
+func (o *CredentialOption) UpdateExpirationTimestamp(t *time.Time) {
+	if t == nil {
+		return
+	}
+	if o.ExpirationTimestamp == nil || t.Before(*o.ExpirationTimestamp) {
+		o.ExpirationTimestamp = t
+	}
+}
+
+// Merge sets the credentials of other. It is an error if a credential is set in both.
+func (o *CredentialOption) Merge(other *CredentialOption) error {
+	if len(other.ClientCertificateData) > 0 {
+		if len(o.ClientCertificateData) > 0 {
+			return errors.New("client certificate is provided by more than one source")
+		}
+		o.ClientCertificateData = other.ClientCertificateData
+	}
+	if len(other.ClientKeyData) > 0 {
+		if len(o.ClientKeyData) > 0 {
+			return errors.New("client key is provided by more than one source")
+		}
+		o.ClientKeyData = other.ClientKeyData
+	}
+	if len(other.Token) > 0 {
+		if len(o.Token) > 0 {
+			return errors.New("token is provided by more than one source")
+		}
+		o.Token = other.Token
+	}
+	o.UpdateExpirationTimestamp(other.ExpirationTimestamp)
+
+	return nil
 }
@@ -0,0 +1,133 @@
+package credentials
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCredentialOption_UpdateExpirationTimestamp(t *testing.T) {
+	earlier := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	later := time.Date(2021, 4, 2, 12, 0, 0, 0, time.UTC)
+
+	type args struct {
+		t *time.Time
+	}
+	tests := []struct {
+		name    string
+		current *time.Time
+		args    args
+		want    *time.Time
+	}{
+		{
+			name:    "set",
+			current: nil,
+			args:    args{t: &later},
+			want:    &later,
+		},
+		{
+			name:    "earlier wins",
+			current: &later,
+			args:    args{t: &earlier},
+			want:    &earlier,
+		},
+		{
+			name:    "later is ignored",
+			current: &earlier,
+			args:    args{t: &later},
+			want:    &earlier,
+		},
+		{
+			name:    "nil is ignored",
+			current: &earlier,
+			args:    args{t: nil},
+			want:    &earlier,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &CredentialOption{ExpirationTimestamp: tt.current}
+			o.UpdateExpirationTimestamp(tt.args.t)
+			if !reflect.DeepEqual(o.ExpirationTimestamp, tt.want) {
+				t.Errorf("CredentialOption.UpdateExpirationTimestamp() = %v, want %v", o.ExpirationTimestamp, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialOption_Merge(t *testing.T) {
+	earlier := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	later := time.Date(2021, 4, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		o       *CredentialOption
+		other   *CredentialOption
+		want    *CredentialOption
+		wantErr bool
+	}{
+		{
+			name: "merge certificate/key and token",
+			o: &CredentialOption{
+				ClientCertificateData: "cert",
+				ExpirationTimestamp:   &later,
+			},
+			other: &CredentialOption{
+				ClientKeyData:       "key",
+				Token:               "token",
+				ExpirationTimestamp: &earlier,
+			},
+			want: &CredentialOption{
+				ClientCertificateData: "cert",
+				ClientKeyData:         "key",
+				Token:                 "token",
+				ExpirationTimestamp:   &earlier,
+			},
+		},
+		{
+			name: "conflict certificate",
+			o: &CredentialOption{
+				ClientCertificateData: "cert1",
+			},
+			other: &CredentialOption{
+				ClientCertificateData: "cert2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflict key",
+			o: &CredentialOption{
+				ClientKeyData: "key1",
+			},
+			other: &CredentialOption{
+				ClientKeyData: "key2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflict token",
+			o: &CredentialOption{
+				Token: "token1",
+			},
+			other: &CredentialOption{
+				Token: "token2",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.o.Merge(tt.other)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CredentialOption.Merge() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(tt.o, tt.want) {
+				t.Errorf("CredentialOption.Merge() = %+v, want %+v", tt.o, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package credentials
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	type args struct {
+		apiVersion string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    Credential
+		wantErr bool
+	}{
+		{
+			name: "v1",
+			args: args{apiVersion: "client.authentication.k8s.io/v1"},
+			want: &V1{},
+		},
+		{
+			name: "v1beta1",
+			args: args{apiVersion: "client.authentication.k8s.io/v1beta1"},
+			want: &V1Beta1{},
+		},
+		{
+			name: "v1alpha1",
+			args: args{apiVersion: "client.authentication.k8s.io/v1alpha1"},
+			want: &V1Alpha1{},
+		},
+		{
+			name:    "unsupported",
+			args:    args{apiVersion: "client.authentication.k8s.io/v2"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.args.apiVersion)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
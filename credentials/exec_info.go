@@ -0,0 +1,39 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ExecInfoEnv is the environment variable in which client-go passes the ExecCredential request to the plugin.
+const ExecInfoEnv = "KUBERNETES_EXEC_INFO"
+
+type ExecInfo struct {
+	APIVersion  string
+	Interactive bool
+}
+
+func ParseExecInfo(data string) (*ExecInfo, error) {
+	v := struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Spec       struct {
+			Interactive bool `json:"interactive"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return nil, err
+	}
+
+	if v.Kind != "ExecCredential" {
+		return nil, errors.New("kind must be ExecCredential")
+	}
+	if v.APIVersion == "" {
+		return nil, errors.New("apiVersion is empty")
+	}
+
+	return &ExecInfo{
+		APIVersion:  v.APIVersion,
+		Interactive: v.Spec.Interactive,
+	}, nil
+}
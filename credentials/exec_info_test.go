@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExecInfo(t *testing.T) {
+	type args struct {
+		data string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *ExecInfo
+		wantErr bool
+	}{
+		{
+			name: "v1",
+			args: args{data: `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":true}}`},
+			want: &ExecInfo{
+				APIVersion:  "client.authentication.k8s.io/v1",
+				Interactive: true,
+			},
+		},
+		{
+			name: "v1beta1 with cluster",
+			args: args{data: `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"cluster":{"server":"https://127.0.0.1"},"interactive":false}}`},
+			want: &ExecInfo{
+				APIVersion: "client.authentication.k8s.io/v1beta1",
+			},
+		},
+		{
+			name:    "invalid kind",
+			args:    args{data: `{"kind":"Config","apiVersion":"v1"}`},
+			wantErr: true,
+		},
+		{
+			name:    "empty apiVersion",
+			args:    args{data: `{"kind":"ExecCredential"}`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			args:    args{data: `ExecCredential`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExecInfo(tt.args.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseExecInfo() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseExecInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package credentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+const encryptedPKCS8BlockType = "ENCRYPTED PRIVATE KEY"
+
+// ErrIncorrectPassphrase is returned when the encrypted private key can not be decrypted with the passphrase.
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase for the private key")
+
+// ErrKeyPairMismatch is returned when the private key does not match the public key of the certificate.
+var ErrKeyPairMismatch = errors.New("client key does not match the client certificate")
+
+func findPrivateKeyBlock(data string) *pem.Block {
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return block
+		}
+	}
+}
+
+// IsEncryptedPrivateKey reports whether the PEM-encoded private key is an encrypted PKCS#8 key
+// or a legacy encrypted PEM key (Proc-Type: 4,ENCRYPTED).
+func IsEncryptedPrivateKey(data string) bool {
+	block := findPrivateKeyBlock(data)
+	if block == nil {
+		return false
+	}
+
+	//lint:ignore SA1019 legacy encrypted PEM keys are still produced by 'openssl genrsa -aes256'
+	return block.Type == encryptedPKCS8BlockType || x509.IsEncryptedPEMBlock(block)
+}
+
+// DecryptPrivateKey returns the PEM-encoded private key decrypted with the passphrase.
+// Encrypted PKCS#8 keys are converted to unencrypted PKCS#8 keys, and legacy encrypted PEM keys keep their type.
+func DecryptPrivateKey(data string, passphrase []byte) (string, error) {
+	block := findPrivateKeyBlock(data)
+	if block == nil {
+		return "", errors.New("no PEM-encoded private key found")
+	}
+
+	if block.Type == encryptedPKCS8BlockType {
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			if strings.Contains(err.Error(), "incorrect password") {
+				return "", ErrIncorrectPassphrase
+			}
+			return "", err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	}
+
+	//lint:ignore SA1019 legacy encrypted PEM keys are still produced by 'openssl genrsa -aes256'
+	if x509.IsEncryptedPEMBlock(block) {
+		//lint:ignore SA1019 see above
+		der, err := x509.DecryptPEMBlock(block, passphrase)
+		if err != nil {
+			if errors.Is(err, x509.IncorrectPasswordError) {
+				return "", ErrIncorrectPassphrase
+			}
+			return "", err
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})), nil
+	}
+
+	return data, nil
+}
+
+// ParsePrivateKey parses the PEM-encoded unencrypted private key in PKCS#1, PKCS#8 or SEC 1 form.
+func ParsePrivateKey(data string) (crypto.Signer, error) {
+	block := findPrivateKeyBlock(data)
+	if block == nil {
+		return nil, errors.New("no PEM-encoded private key found")
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key: %T", key)
+	}
+
+	return signer, nil
+}
+
+// ValidateKeyPair confirms that the private key matches the public key of the leaf certificate.
+func ValidateKeyPair(certificateData, keyData string) error {
+	leaf, err := LeafCertificate(certificateData)
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	key, err := ParsePrivateKey(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse client key: %w", err)
+	}
+
+	keyAlgorithm := publicKeyAlgorithm(key.Public())
+	certAlgorithm := publicKeyAlgorithm(leaf.PublicKey)
+	if keyAlgorithm != certAlgorithm {
+		return fmt.Errorf("%w: %s key for %s certificate (subject: %s, serial: %s)", ErrKeyPairMismatch, keyAlgorithm, certAlgorithm, leaf.Subject, leaf.SerialNumber)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return fmt.Errorf("%w: %s public keys differ (subject: %s, serial: %s)", ErrKeyPairMismatch, certAlgorithm, leaf.Subject, leaf.SerialNumber)
+	}
+
+	return nil
+}
+
+func publicKeyAlgorithm(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "ECDSA"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
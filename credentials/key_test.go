@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+	"github.com/youmark/pkcs8"
+)
+
+func TestDecryptPrivateKey(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	passphrase := []byte("passphrase")
+
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	plainPKCS8 := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}))
+
+	encryptedDER, err := pkcs8.MarshalPrivateKey(cert.PrivateKey, passphrase, nil)
+	if err != nil {
+		t.Fatalf("pkcs8.MarshalPrivateKey() error = %v", err)
+	}
+	encryptedPKCS8 := string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptedDER}))
+
+	keyBlock, _ := pem.Decode([]byte(cert.KeyPEM))
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, passphrase, x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("x509.EncryptPEMBlock() error = %v", err)
+	}
+	encryptedLegacy := string(pem.EncodeToMemory(legacyBlock))
+
+	tests := []struct {
+		name          string
+		data          string
+		passphrase    []byte
+		wantEncrypted bool
+		want          string
+		wantErr       error
+	}{
+		{
+			name:          "encrypted PKCS#8",
+			data:          encryptedPKCS8,
+			passphrase:    passphrase,
+			wantEncrypted: true,
+			want:          plainPKCS8,
+		},
+		{
+			name:          "encrypted PKCS#8 with incorrect passphrase",
+			data:          encryptedPKCS8,
+			passphrase:    []byte("incorrect"),
+			wantEncrypted: true,
+			wantErr:       ErrIncorrectPassphrase,
+		},
+		{
+			name:          "legacy encrypted PEM",
+			data:          encryptedLegacy,
+			passphrase:    passphrase,
+			wantEncrypted: true,
+			want:          cert.KeyPEM,
+		},
+		{
+			name: "not encrypted",
+			data: cert.KeyPEM,
+			want: cert.KeyPEM,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEncryptedPrivateKey(tt.data); got != tt.wantEncrypted {
+				t.Errorf("IsEncryptedPrivateKey() = %v, want %v", got, tt.wantEncrypted)
+			}
+
+			got, err := DecryptPrivateKey(tt.data, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptPrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DecryptPrivateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyPair(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ecCert := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	otherCert := testutil.GenerateCertificate(t, "other", now.Add(-time.Hour), now.Add(time.Hour), nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	rsaCertPEM := selfSignedCertificatePEM(t, rsaKey.Public(), rsaKey)
+	rsaKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	ed25519CertPEM := selfSignedCertificatePEM(t, ed25519Key.Public(), ed25519Key)
+	ed25519DER, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	ed25519KeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ed25519DER}))
+
+	tests := []struct {
+		name            string
+		certificateData string
+		keyData         string
+		wantErr         bool
+		wantMismatch    bool
+	}{
+		{
+			name:            "ECDSA",
+			certificateData: ecCert.CertPEM,
+			keyData:         ecCert.KeyPEM,
+		},
+		{
+			name:            "RSA",
+			certificateData: rsaCertPEM,
+			keyData:         rsaKeyPEM,
+		},
+		{
+			name:            "Ed25519",
+			certificateData: ed25519CertPEM,
+			keyData:         ed25519KeyPEM,
+		},
+		{
+			name:            "mismatched ECDSA key",
+			certificateData: ecCert.CertPEM,
+			keyData:         otherCert.KeyPEM,
+			wantErr:         true,
+			wantMismatch:    true,
+		},
+		{
+			name:            "mismatched key type",
+			certificateData: rsaCertPEM,
+			keyData:         ed25519KeyPEM,
+			wantErr:         true,
+			wantMismatch:    true,
+		},
+		{
+			name:            "invalid certificate",
+			certificateData: "invalid",
+			keyData:         ecCert.KeyPEM,
+			wantErr:         true,
+		},
+		{
+			name:            "invalid key",
+			certificateData: ecCert.CertPEM,
+			keyData:         "invalid",
+			wantErr:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKeyPair(tt.certificateData, tt.keyData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateKeyPair() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if errors.Is(err, ErrKeyPairMismatch) != tt.wantMismatch {
+				t.Errorf("ValidateKeyPair() error = %v, wantMismatch %v", err, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func selfSignedCertificatePEM(t *testing.T, pub crypto.PublicKey, priv crypto.Signer) string {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
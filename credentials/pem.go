@@ -0,0 +1,29 @@
+package credentials
+
+import (
+	"encoding/pem"
+	"strings"
+)
+
+// SplitPEM splits PEM-encoded data into the certificate blocks and the private key blocks.
+// Other blocks and text between blocks are dropped.
+func SplitPEM(data string) (certificates string, key string) {
+	var certs, keys []byte
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE":
+			certs = append(certs, pem.EncodeToMemory(block)...)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			keys = append(keys, pem.EncodeToMemory(block)...)
+		}
+	}
+
+	return string(certs), string(keys)
+}
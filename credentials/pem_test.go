@@ -0,0 +1,54 @@
+package credentials
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestSplitPEM(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	leaf := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	tests := []struct {
+		name             string
+		data             string
+		wantCertificates string
+		wantKey          string
+	}{
+		{
+			name:             "certificates and key",
+			data:             leaf.CertPEM + ca.CertPEM + leaf.KeyPEM,
+			wantCertificates: leaf.CertPEM + ca.CertPEM,
+			wantKey:          leaf.KeyPEM,
+		},
+		{
+			name:             "key first with comments",
+			data:             "# key\n" + leaf.KeyPEM + "# certificate\n" + leaf.CertPEM,
+			wantCertificates: leaf.CertPEM,
+			wantKey:          leaf.KeyPEM,
+		},
+		{
+			name:             "certificate only",
+			data:             leaf.CertPEM,
+			wantCertificates: leaf.CertPEM,
+		},
+		{
+			name: "not PEM",
+			data: "client-certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCertificates, gotKey := SplitPEM(tt.data)
+			if gotCertificates != tt.wantCertificates {
+				t.Errorf("SplitPEM() certificates = %v, want %v", gotCertificates, tt.wantCertificates)
+			}
+			if gotKey != tt.wantKey {
+				t.Errorf("SplitPEM() key = %v, want %v", gotKey, tt.wantKey)
+			}
+		})
+	}
+}
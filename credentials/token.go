@@ -0,0 +1,50 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+type JWTClaims struct {
+	ExpiresAt *float64 `json:"exp,omitempty"`
+	NotBefore *float64 `json:"nbf,omitempty"`
+	IssuedAt  *float64 `json:"iat,omitempty"`
+}
+
+// ParseJWTClaims decodes the payload of a JWT without verifying the signature.
+func ParseJWTClaims(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &JWTClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// TokenExpirationTimestamp returns the exp claim if the token is a JWT, otherwise nil.
+func TokenExpirationTimestamp(token string) *time.Time {
+	claims, err := ParseJWTClaims(token)
+	if err != nil || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	return numericDate(*claims.ExpiresAt)
+}
+
+func numericDate(f float64) *time.Time {
+	t := time.Unix(int64(f), 0).UTC()
+	return &t
+}
@@ -0,0 +1,48 @@
+package credentials
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestTokenExpirationTimestamp(t *testing.T) {
+	type args struct {
+		token string
+	}
+	tests := []struct {
+		name string
+		args args
+		want *time.Time
+	}{
+		{
+			name: "JWT with exp",
+			args: args{token: testutil.GenerateJWT(t, map[string]interface{}{"sub": "user1", "exp": 1617278400})},
+			want: timePtr(time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)),
+		},
+		{
+			name: "JWT without exp",
+			args: args{token: testutil.GenerateJWT(t, map[string]interface{}{"sub": "user1"})},
+			want: nil,
+		},
+		{
+			name: "opaque token",
+			args: args{token: "token-from-file"},
+			want: nil,
+		},
+		{
+			name: "broken JWT payload",
+			args: args{token: "header.!!!.signature"},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TokenExpirationTimestamp(tt.args.token); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TokenExpirationTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package credentials
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+type V1 struct{}
+
+func (o *V1) APIVersionString() string {
+	return "client.authentication.k8s.io/v1"
+}
+
+func (o *V1) ToJSON(opts *CredentialOption) ([]byte, error) {
+	status := &clientauthenticationv1.ExecCredentialStatus{}
+	if len(opts.ClientCertificateData) > 0 {
+		status.ClientCertificateData = opts.ClientCertificateData
+	}
+	if len(opts.ClientKeyData) > 0 {
+		status.ClientKeyData = opts.ClientKeyData
+	}
+	if len(opts.Token) > 0 {
+		status.Token = opts.Token
+	}
+	if opts.ExpirationTimestamp != nil {
+		status.ExpirationTimestamp = &metav1.Time{Time: *opts.ExpirationTimestamp}
+	}
+
+	return json.Marshal(&clientauthenticationv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "client.authentication.k8s.io/v1",
+			Kind:       "ExecCredential",
+		},
+		Status: status,
+	})
+}
@@ -0,0 +1,55 @@
+package credentials
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestV1_ToJSON(t *testing.T) {
+	type args struct {
+		opts *CredentialOption
+	}
+	tests := []struct {
+		name    string
+		o       *V1
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			args: args{opts: &CredentialOption{
+				ClientCertificateData: "hoge",
+				ClientKeyData:         "foo",
+				Token:                 "bar",
+			}},
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false},"status":{"token":"bar","clientCertificateData":"hoge","clientKeyData":"foo"}}`),
+		},
+		{
+			name: "with expiration timestamp",
+			args: args{opts: &CredentialOption{
+				Token:               "bar",
+				ExpirationTimestamp: timePtr(time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)),
+			}},
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false},"status":{"expirationTimestamp":"2021-04-01T12:00:00Z","token":"bar"}}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &V1{}
+			got, err := o.ToJSON(tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("V1.ToJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("V1.ToJSON() = %v, want %v", string(got), string(tt.want))
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
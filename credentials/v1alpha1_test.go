@@ -3,6 +3,7 @@ package credentials
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestV1Alpha1_ToJSON(t *testing.T) {
@@ -25,6 +26,14 @@ func TestV1Alpha1_ToJSON(t *testing.T) {
 			}},
 			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1alpha1","spec":{},"status":{"token":"bar","clientCertificateData":"hoge","clientKeyData":"foo"}}`),
 		},
+		{
+			name: "with expiration timestamp",
+			args: args{opts: &CredentialOption{
+				Token:               "bar",
+				ExpirationTimestamp: timePtr(time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)),
+			}},
+			want: []byte(`{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1alpha1","spec":{},"status":{"expirationTimestamp":"2021-04-01T12:00:00Z","token":"bar"}}`),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -24,6 +24,9 @@ func (o *V1Beta1) ToJSON(opts *CredentialOption) ([]byte, error) {
 	if len(opts.Token) > 0 {
 		status.Token = opts.Token
 	}
+	if opts.ExpirationTimestamp != nil {
+		status.ExpirationTimestamp = &metav1.Time{Time: *opts.ExpirationTimestamp}
+	}
 
 	return json.Marshal(&clientauthenticationv1beta1.ExecCredential{
 		TypeMeta: metav1.TypeMeta{
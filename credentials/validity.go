@@ -0,0 +1,59 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrExpired is returned when the certificate or the token has expired.
+	ErrExpired = errors.New("expired")
+	// ErrNotYetValid is returned when the certificate or the token is not valid yet.
+	ErrNotYetValid = errors.New("not yet valid")
+)
+
+// CheckValidity checks the validity period of the leaf client certificate and the exp and nbf claims of the token.
+// A token which is not a JWT is always valid.
+func (o *CredentialOption) CheckValidity(now time.Time) error {
+	if len(o.ClientCertificateData) > 0 {
+		cert, err := LeafCertificate(o.ClientCertificateData)
+		if err != nil {
+			return fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		name := fmt.Sprintf("client certificate (subject: %s)", cert.Subject)
+		if err := checkPeriod(name, &cert.NotBefore, &cert.NotAfter, now); err != nil {
+			return err
+		}
+	}
+
+	if len(o.Token) > 0 {
+		claims, err := ParseJWTClaims(o.Token)
+		if err != nil {
+			return nil
+		}
+		var notBefore, notAfter *time.Time
+		if claims.NotBefore != nil {
+			notBefore = numericDate(*claims.NotBefore)
+		}
+		if claims.ExpiresAt != nil {
+			notAfter = numericDate(*claims.ExpiresAt)
+		}
+		if err := checkPeriod("token", notBefore, notAfter, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkPeriod(name string, notBefore, notAfter *time.Time, now time.Time) error {
+	if notAfter != nil && now.After(*notAfter) {
+		return fmt.Errorf("%s %w at %s (%s ago)", name, ErrExpired, notAfter.Format(time.RFC3339), now.Sub(*notAfter).Truncate(time.Second))
+	}
+	if notBefore != nil && now.Before(*notBefore) {
+		return fmt.Errorf("%s is %w (valid from %s)", name, ErrNotYetValid, notBefore.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package credentials
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestCredentialOption_CheckValidity(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	valid := testutil.GenerateCertificate(t, "valid", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	expired := testutil.GenerateCertificate(t, "expired", now.Add(-2*time.Hour), now.Add(-time.Hour), nil)
+	future := testutil.GenerateCertificate(t, "future", now.Add(time.Hour), now.Add(2*time.Hour), nil)
+	leaf := testutil.GenerateCertificate(t, "leaf", now.Add(-time.Hour), now.Add(time.Hour), expired)
+
+	tests := []struct {
+		name    string
+		opt     *CredentialOption
+		wantErr error
+	}{
+		{
+			name: "valid certificate",
+			opt:  &CredentialOption{ClientCertificateData: valid.CertPEM},
+		},
+		{
+			name:    "expired certificate",
+			opt:     &CredentialOption{ClientCertificateData: expired.CertPEM},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "not yet valid certificate",
+			opt:     &CredentialOption{ClientCertificateData: future.CertPEM},
+			wantErr: ErrNotYetValid,
+		},
+		{
+			name: "only the leaf certificate is checked",
+			opt:  &CredentialOption{ClientCertificateData: leaf.CertPEM + expired.CertPEM},
+		},
+		{
+			name: "valid JWT",
+			opt:  &CredentialOption{Token: testutil.GenerateJWT(t, map[string]interface{}{"nbf": now.Add(-time.Hour).Unix(), "exp": now.Add(time.Hour).Unix()})},
+		},
+		{
+			name:    "expired JWT",
+			opt:     &CredentialOption{Token: testutil.GenerateJWT(t, map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "not yet valid JWT",
+			opt:     &CredentialOption{Token: testutil.GenerateJWT(t, map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})},
+			wantErr: ErrNotYetValid,
+		},
+		{
+			name: "JWT without exp and nbf",
+			opt:  &CredentialOption{Token: testutil.GenerateJWT(t, map[string]interface{}{"sub": "user1"})},
+		},
+		{
+			name: "opaque token",
+			opt:  &CredentialOption{Token: "token-from-file"},
+		},
+		{
+			name:    "expired certificate with valid token",
+			opt:     &CredentialOption{ClientCertificateData: expired.CertPEM, Token: "token-from-file"},
+			wantErr: ErrExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opt.CheckValidity(now)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("CredentialOption.CheckValidity() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CredentialOption.CheckValidity() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
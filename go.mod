@@ -4,10 +4,17 @@ go 1.16
 
 require (
 	github.com/Songmu/prompter v0.5.0
-	github.com/google/go-cmp v0.5.2
+	github.com/google/go-cmp v0.5.5
+	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
 	github.com/sergi/go-diff v1.2.0
 	github.com/spf13/cobra v1.1.3
-	k8s.io/apimachinery v0.21.0
-	k8s.io/client-go v0.21.0
+	github.com/spf13/pflag v1.0.5
+	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a
+	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22
+	golang.org/x/term v0.0.0-20210317153231-de623e64d2a6
+	k8s.io/api v0.22.17
+	k8s.io/apimachinery v0.22.17
+	k8s.io/client-go v0.22.17
+	software.sslmate.com/src/go-pkcs12 v0.2.0
 )
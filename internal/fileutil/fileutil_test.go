@@ -0,0 +1,55 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "sub", "file")
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(data), 0600); err != nil {
+			t.Errorf("WriteFileAtomic() error = %v", err)
+			return
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("ioutil.ReadFile() error = %v", err)
+			return
+		}
+		if string(buf) != data {
+			t.Errorf("WriteFileAtomic() wrote %q, want %q", string(buf), data)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Errorf("ioutil.ReadDir() error = %v", err)
+		return
+	}
+	if len(files) != 1 {
+		t.Errorf("WriteFileAtomic() left %d files, want 1", len(files))
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Errorf("os.Stat() error = %v", err)
+		return
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("WriteFileAtomic() mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
@@ -0,0 +1,39 @@
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// CheckPrivate returns an error if the file is readable or writable by the group or others, or its parent directory
+// is writable by them, as ssh does for private keys. A missing file is not an error. The check is skipped on Windows,
+// where the permission bits do not reflect the ACL.
+func CheckPrivate(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("permissions %04o for '%s' are too open, it must not be accessible by the group or others (chmod 600)", perm, path)
+	}
+
+	dir := filepath.Dir(path)
+	fi, err = os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("permissions %04o for directory '%s' are too open, it must not be writable by the group or others (chmod go-w)", perm, dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCheckPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on windows")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tests := []struct {
+		name     string
+		dirPerm  os.FileMode
+		filePerm os.FileMode
+		missing  bool
+		wantErr  bool
+	}{
+		{
+			name:     "private",
+			dirPerm:  0700,
+			filePerm: 0600,
+		},
+		{
+			name:     "read-only",
+			dirPerm:  0700,
+			filePerm: 0400,
+		},
+		{
+			name:     "group readable file",
+			dirPerm:  0700,
+			filePerm: 0640,
+			wantErr:  true,
+		},
+		{
+			name:     "world readable file",
+			dirPerm:  0700,
+			filePerm: 0604,
+			wantErr:  true,
+		},
+		{
+			name:     "world writable directory",
+			dirPerm:  0703,
+			filePerm: 0600,
+			wantErr:  true,
+		},
+		{
+			name:     "group writable directory",
+			dirPerm:  0770,
+			filePerm: 0600,
+			wantErr:  true,
+		},
+		{
+			name:     "world readable directory",
+			dirPerm:  0755,
+			filePerm: 0600,
+		},
+		{
+			name:    "missing file",
+			dirPerm: 0755,
+			missing: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(testDir, tt.name)
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Errorf("os.Mkdir() error = %v", err)
+				return
+			}
+			path := filepath.Join(dir, "tls.key")
+			if !tt.missing {
+				if err := ioutil.WriteFile(path, []byte("key"), 0600); err != nil {
+					t.Errorf("ioutil.WriteFile() error = %v", err)
+					return
+				}
+				if err := os.Chmod(path, tt.filePerm); err != nil {
+					t.Errorf("os.Chmod() error = %v", err)
+					return
+				}
+			}
+			if err := os.Chmod(dir, tt.dirPerm); err != nil {
+				t.Errorf("os.Chmod() error = %v", err)
+				return
+			}
+
+			if err := CheckPrivate(path); (err != nil) != tt.wantErr {
+				t.Errorf("CheckPrivate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package testutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// GenerateJWT returns an unsigned JWT with the given claims.
+func GenerateJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
@@ -10,6 +10,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd/api"
 )
 
+const execAPIVersionV1 = "client.authentication.k8s.io/v1"
+
 type Kubeconfig struct {
 	clientConfig   clientcmd.ClientConfig
 	configFilePath string
@@ -133,6 +135,11 @@ func (k *Kubeconfig) updateCurrentUserExecConfig(apiVersion, cmd string, args []
 		Env:        envVars,
 	}
 
+	// interactiveMode is required since client.authentication.k8s.io/v1
+	if apiVersion == execAPIVersionV1 {
+		rawConfig.AuthInfos[cc.AuthInfo].Exec.InteractiveMode = api.IfAvailableExecInteractiveMode
+	}
+
 	rawConfig.AuthInfos[cc.AuthInfo].Token = ""
 
 	return rawConfig, nil
@@ -536,6 +536,62 @@ users:
       - name: ENV1
         value: val1
       provideClusterInfo: false
+`,
+		},
+		{
+			name: "client.authentication.k8s.io/v1 requires interactiveMode",
+			args: args{
+				apiVersion: "client.authentication.k8s.io/v1",
+				cmd:        "/cmd",
+				args:       []string{"-args1"},
+				envs:       map[string]string{},
+			},
+			fields: fields{
+				kubeconfigString: `apiVersion: v1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    namespace: kube-system
+    user: user1
+  name: context1
+current-context: context1
+kind: Config
+preferences: {}
+users:
+- name: user1
+  user:
+    token: hoge
+`,
+			},
+			want: `apiVersion: v1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: server1
+contexts:
+- context:
+    cluster: server1
+    namespace: kube-system
+    user: user1
+  name: context1
+current-context: context1
+kind: Config
+preferences: {}
+users:
+- name: user1
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      args:
+      - -args1
+      command: /cmd
+      env: []
+      interactiveMode: IfAvailable
+      provideClusterInfo: false
 `,
 		},
 	}
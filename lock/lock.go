@@ -0,0 +1,40 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lock is an advisory lock on a file shared between processes.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire blocks until the exclusive lock on the path is acquired.
+// The file and its parent directory are created if they do not exist.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Lock{f: f}, nil
+}
+
+func (l *Lock) Release() error {
+	if err := unlockFile(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
@@ -0,0 +1,57 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "dir", "test.lock")
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Errorf("Acquire() error = %v", err)
+		return
+	}
+
+	var mu sync.Mutex
+	released := false
+	acquired := make(chan bool)
+	go func() {
+		l2, err := Acquire(path)
+		if err != nil {
+			t.Errorf("Acquire() error = %v", err)
+			acquired <- false
+			return
+		}
+		defer l2.Release()
+
+		mu.Lock()
+		defer mu.Unlock()
+		acquired <- released
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	released = true
+	mu.Unlock()
+	if err := l.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+		return
+	}
+
+	if !<-acquired {
+		t.Errorf("Acquire() returned before the lock was released")
+	}
+}
@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+const (
+	deviceCodeGrantType   = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultDeviceInterval = 5 * time.Second
+	slowDownInterval      = 5 * time.Second
+)
+
+// DeviceAuthorization is a response of the device authorization endpoint defined in RFC 8628.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+// AuthorizeDevice starts the device authorization grant. The user has to visit VerificationURI and enter UserCode.
+func (c *Client) AuthorizeDevice(ctx context.Context, p *Provider) (*DeviceAuthorization, error) {
+	if len(p.DeviceAuthorizationEndpoint) == 0 {
+		return nil, errors.New("device_authorization_endpoint is not found in the provider metadata")
+	}
+
+	da := &DeviceAuthorization{}
+	if err := c.postForm(ctx, p.DeviceAuthorizationEndpoint, url.Values{"scope": {c.scope()}}, da); err != nil {
+		return nil, err
+	}
+	if len(da.DeviceCode) == 0 || len(da.UserCode) == 0 || len(da.VerificationURI) == 0 {
+		return nil, errors.New("device_code, user_code and verification_uri are required in the device authorization response")
+	}
+
+	return da, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approves or denies the device authorization, or it expires.
+func (c *Client) PollDeviceToken(ctx context.Context, p *Provider, da *DeviceAuthorization) (*Token, error) {
+	interval := time.Duration(da.Interval) * time.Second
+	if interval == 0 {
+		interval = defaultDeviceInterval
+	}
+	if da.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(da.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	after := time.After
+	if c.after != nil {
+		after = c.after
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("device authorization expired")
+		case <-after(interval):
+		}
+
+		token, err := c.requestToken(ctx, p.TokenEndpoint, url.Values{
+			"grant_type":  {deviceCodeGrantType},
+			"device_code": {da.DeviceCode},
+		})
+		if err == nil {
+			return token, nil
+		}
+
+		var e *Error
+		if !errors.As(err, &e) {
+			return nil, err
+		}
+		switch e.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownInterval
+		default:
+			return nil, e
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClient_DeviceAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  []string
+		want       *Token
+		wantPolls  int
+		wantErr    bool
+		wantWaited time.Duration
+	}{
+		{
+			name:       "approved",
+			responses:  []string{`{"error":"authorization_pending"}`, `{"error":"slow_down"}`, `{"id_token":"id-token1","refresh_token":"refresh-token1"}`},
+			want:       &Token{IDToken: "id-token1", RefreshToken: "refresh-token1"},
+			wantPolls:  3,
+			wantWaited: 1*time.Second + 1*time.Second + 6*time.Second,
+		},
+		{
+			name:       "denied",
+			responses:  []string{`{"error":"authorization_pending"}`, `{"error":"access_denied"}`},
+			wantPolls:  2,
+			wantErr:    true,
+			wantWaited: 2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			var server *httptest.Server
+			mux := http.NewServeMux()
+			mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&Provider{
+					Issuer:                      server.URL,
+					TokenEndpoint:               server.URL + "/token",
+					DeviceAuthorizationEndpoint: server.URL + "/device",
+				})
+			})
+			mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.PostForm.Get("client_id") != "client1" || r.PostForm.Get("scope") != "openid offline_access" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error":"invalid_request"}`)
+					return
+				}
+				fmt.Fprint(w, `{"device_code":"device-code1","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600,"interval":1}`)
+			})
+			mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.PostForm.Get("grant_type") != deviceCodeGrantType || r.PostForm.Get("device_code") != "device-code1" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error":"invalid_grant"}`)
+					return
+				}
+				res := tt.responses[polls]
+				polls++
+				if polls < len(tt.responses) || tt.wantErr {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				fmt.Fprint(w, res)
+			})
+			server = httptest.NewServer(mux)
+			defer server.Close()
+
+			var waited time.Duration
+			c := &Client{
+				IssuerURL: server.URL,
+				ClientID:  "client1",
+				Scopes:    []string{"offline_access"},
+				after: func(d time.Duration) <-chan time.Time {
+					waited += d
+					ch := make(chan time.Time, 1)
+					ch <- time.Now()
+					return ch
+				},
+			}
+			p, err := c.Discover(context.Background())
+			if err != nil {
+				t.Errorf("Client.Discover() error = %v", err)
+				return
+			}
+			da, err := c.AuthorizeDevice(context.Background(), p)
+			if err != nil {
+				t.Errorf("Client.AuthorizeDevice() error = %v", err)
+				return
+			}
+			if da.UserCode != "ABCD-EFGH" || da.VerificationURI != "https://example.com/device" {
+				t.Errorf("Client.AuthorizeDevice() = %v", da)
+			}
+
+			got, err := c.PollDeviceToken(context.Background(), p, da)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.PollDeviceToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Client.PollDeviceToken() = %v, want %v", got, tt.want)
+			}
+			if polls != tt.wantPolls {
+				t.Errorf("Client.PollDeviceToken() polled %d times, want %d", polls, tt.wantPolls)
+			}
+			if waited != tt.wantWaited {
+				t.Errorf("Client.PollDeviceToken() waited %s, want %s", waited, tt.wantWaited)
+			}
+		})
+	}
+}
+
+func TestClient_AuthorizeDevice_notSupported(t *testing.T) {
+	c := &Client{IssuerURL: "https://issuer.example.com", ClientID: "client1"}
+	if _, err := c.AuthorizeDevice(context.Background(), &Provider{TokenEndpoint: "https://issuer.example.com/token"}); err == nil {
+		t.Errorf("Client.AuthorizeDevice() error = nil, wantErr true")
+	}
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Provider is the subset of the OpenID Provider Metadata used by the broker.
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+}
+
+// Token is a successful response of the token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Error is an error response defined in RFC 6749 section 5.2.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Description) > 0 {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+
+	return e.Code
+}
+
+type Client struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested in addition to 'openid'.
+	Scopes     []string
+	HTTPClient *http.Client
+
+	after func(time.Duration) <-chan time.Time
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+func (c *Client) scope() string {
+	return strings.Join(append([]string{"openid"}, c.Scopes...), " ")
+}
+
+// Discover fetches the OpenID Provider Metadata of the issuer.
+func (c *Client) Discover(ctx context.Context) (*Provider, error) {
+	u := strings.TrimSuffix(c.IssuerURL, "/") + discoveryPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s: %s", u, res.Status, strings.TrimSpace(string(buf)))
+	}
+
+	p := &Provider{}
+	if err := json.Unmarshal(buf, p); err != nil {
+		return nil, fmt.Errorf("GET %s: invalid JSON response: %w", u, err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != strings.TrimSuffix(c.IssuerURL, "/") {
+		return nil, fmt.Errorf("issuer %s in the provider metadata does not match %s", p.Issuer, c.IssuerURL)
+	}
+	if len(p.TokenEndpoint) == 0 {
+		return nil, errors.New("token_endpoint is not found in the provider metadata")
+	}
+
+	return p, nil
+}
+
+// Refresh exchanges the refresh token for new tokens. The provider may rotate the refresh token,
+// so the caller must persist Token.RefreshToken if it is not empty.
+func (c *Client) Refresh(ctx context.Context, p *Provider, refreshToken string) (*Token, error) {
+	return c.requestToken(ctx, p.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"scope":         {c.scope()},
+	})
+}
+
+func (c *Client) requestToken(ctx context.Context, endpoint string, form url.Values) (*Token, error) {
+	token := &Token{}
+	if err := c.postForm(ctx, endpoint, form, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// postForm posts the form with the client credentials and decodes the JSON response into v.
+func (c *Client) postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	form.Set("client_id", c.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(c.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		e := &Error{}
+		if err := json.Unmarshal(buf, e); err == nil && len(e.Code) > 0 {
+			return e
+		}
+		return fmt.Errorf("POST %s: %s: %s", endpoint, res.Status, strings.TrimSpace(string(buf)))
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("POST %s: invalid JSON response: %w", endpoint, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Provider{
+			Issuer:        server.URL,
+			TokenEndpoint: server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if id, secret, _ := r.BasicAuth(); id != "client1" || secret != "secret1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh-token1" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"refresh token is expired"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(&Token{
+			IDToken:      "id-token-for-" + r.PostForm.Get("scope"),
+			RefreshToken: "refresh-token2",
+			TokenType:    "Bearer",
+			ExpiresIn:    3600,
+		})
+	})
+	server = httptest.NewServer(mux)
+
+	return server
+}
+
+func TestClient_Discover(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		issuerURL string
+		want      *Provider
+		wantErr   bool
+	}{
+		{
+			name:      "ok",
+			issuerURL: server.URL,
+			want: &Provider{
+				Issuer:        server.URL,
+				TokenEndpoint: server.URL + "/token",
+			},
+		},
+		{
+			name:      "trailing slash",
+			issuerURL: server.URL + "/",
+			want: &Provider{
+				Issuer:        server.URL,
+				TokenEndpoint: server.URL + "/token",
+			},
+		},
+		{
+			name:      "issuer mismatch",
+			issuerURL: server.URL + "/token",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{IssuerURL: tt.issuerURL}
+			got, err := c.Discover(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.Discover() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Client.Discover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Refresh(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		client       *Client
+		refreshToken string
+		want         *Token
+		wantErrCode  string
+	}{
+		{
+			name:         "ok",
+			client:       &Client{IssuerURL: server.URL, ClientID: "client1", ClientSecret: "secret1", Scopes: []string{"email", "groups"}},
+			refreshToken: "refresh-token1",
+			want: &Token{
+				IDToken:      "id-token-for-openid email groups",
+				RefreshToken: "refresh-token2",
+				TokenType:    "Bearer",
+				ExpiresIn:    3600,
+			},
+		},
+		{
+			name:         "invalid grant",
+			client:       &Client{IssuerURL: server.URL, ClientID: "client1", ClientSecret: "secret1"},
+			refreshToken: "refresh-token0",
+			wantErrCode:  "invalid_grant",
+		},
+		{
+			name:         "invalid client",
+			client:       &Client{IssuerURL: server.URL, ClientID: "client1", ClientSecret: "secret2"},
+			refreshToken: "refresh-token1",
+			wantErrCode:  "invalid_client",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.client.Discover(context.Background())
+			if err != nil {
+				t.Errorf("Client.Discover() error = %v", err)
+				return
+			}
+			got, err := tt.client.Refresh(context.Background(), p, tt.refreshToken)
+			if len(tt.wantErrCode) > 0 {
+				var e *Error
+				if !errors.As(err, &e) || e.Code != tt.wantErrCode {
+					t.Errorf("Client.Refresh() error = %v, want %s", err, tt.wantErrCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Client.Refresh() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Client.Refresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package oidc
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/fileutil"
+)
+
+// ReadRefreshToken reads the refresh token stored in the file.
+func ReadRefreshToken(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// WriteRefreshToken replaces the refresh token stored in the file.
+func WriteRefreshToken(path, refreshToken string) error {
+	return fileutil.WriteFileAtomic(path, []byte(refreshToken+"\n"), 0600)
+}
@@ -0,0 +1,37 @@
+package oidc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefreshToken(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "oidc", "refresh-token")
+	if _, err := ReadRefreshToken(path); !os.IsNotExist(err) {
+		t.Errorf("ReadRefreshToken() error = %v, want not exist", err)
+	}
+
+	for _, want := range []string{"refresh-token1", "refresh-token2"} {
+		if err := WriteRefreshToken(path, want); err != nil {
+			t.Errorf("WriteRefreshToken() error = %v", err)
+			return
+		}
+		got, err := ReadRefreshToken(path)
+		if err != nil {
+			t.Errorf("ReadRefreshToken() error = %v", err)
+			return
+		}
+		if got != want {
+			t.Errorf("ReadRefreshToken() = %v, want %v", got, want)
+		}
+	}
+}
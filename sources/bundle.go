@@ -0,0 +1,82 @@
+package sources
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+// Bundle reads the client certificate, the intermediate certificates and the key from a single PEM file.
+type Bundle struct {
+	Path string
+}
+
+func init() {
+	Register("bundle", func(params Params) (Source, error) {
+		if len(params["path"]) == 0 {
+			return nil, errors.New("path is required")
+		}
+		return &Bundle{Path: params["path"]}, nil
+	})
+}
+
+func (s *Bundle) PrivateFiles() []string {
+	return []string{s.Path}
+}
+
+func (s *Bundle) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, keyPEM, err := parseBundle(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("bundle: %s: %w", s.Path, err)
+	}
+
+	opts, err := NewOption(ClientCertificate, certPEM)
+	if err != nil {
+		return nil, err
+	}
+	opts.ClientKeyData = keyPEM
+
+	return opts, nil
+}
+
+// parseBundle returns the certificates ordered from the leaf to the root and the private key.
+func parseBundle(data string) (string, string, error) {
+	certData, keyData := credentials.SplitPEM(data)
+	if n := strings.Count(keyData, "-----BEGIN "); n == 0 {
+		return "", "", errors.New("no private key found")
+	} else if n > 1 {
+		return "", "", errors.New("more than one private key found")
+	}
+
+	certs, err := credentials.ParseCertificates(certData)
+	if err != nil {
+		return "", "", err
+	}
+	certs, err = credentials.OrderCertificates(certs)
+	if err != nil {
+		return "", "", err
+	}
+	var b strings.Builder
+	for _, cert := range certs {
+		b.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	// An encrypted key can not be compared with the certificate until it is decrypted.
+	if !credentials.IsEncryptedPrivateKey(keyData) {
+		if err := credentials.ValidateKeyPair(b.String(), keyData); err != nil {
+			return "", "", err
+		}
+	}
+
+	return b.String(), keyData, nil
+}
@@ -0,0 +1,119 @@
+package sources
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestBundle_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	root := testutil.GenerateCertificate(t, "root", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	intermediate := testutil.GenerateCertificate(t, "intermediate", now.Add(-time.Hour), now.Add(12*time.Hour), root)
+	leaf := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), intermediate)
+	other := testutil.GenerateCertificate(t, "user2", now.Add(-time.Hour), now.Add(time.Hour), intermediate)
+
+	keyBlock, _ := pem.Decode([]byte(leaf.KeyPEM))
+	encryptedBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Errorf("x509.EncryptPEMBlock() error = %v", err)
+		return
+	}
+	encryptedKeyPEM := string(pem.EncodeToMemory(encryptedBlock))
+
+	tests := []struct {
+		name     string
+		data     string
+		wantCert string
+		wantKey  string
+		wantErr  bool
+	}{
+		{
+			name:     "ordered",
+			data:     leaf.CertPEM + intermediate.CertPEM + root.CertPEM + leaf.KeyPEM,
+			wantCert: leaf.CertPEM + intermediate.CertPEM + root.CertPEM,
+			wantKey:  leaf.KeyPEM,
+		},
+		{
+			name:     "key first and chain reversed",
+			data:     leaf.KeyPEM + root.CertPEM + "comment\n" + intermediate.CertPEM + leaf.CertPEM,
+			wantCert: leaf.CertPEM + intermediate.CertPEM + root.CertPEM,
+			wantKey:  leaf.KeyPEM,
+		},
+		{
+			name:     "encrypted key is passed through",
+			data:     intermediate.CertPEM + leaf.CertPEM + encryptedKeyPEM,
+			wantCert: leaf.CertPEM + intermediate.CertPEM,
+			wantKey:  encryptedKeyPEM,
+		},
+		{
+			name:    "key does not match the leaf",
+			data:    leaf.CertPEM + intermediate.CertPEM + other.KeyPEM,
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			data:    leaf.CertPEM + intermediate.CertPEM,
+			wantErr: true,
+		},
+		{
+			name:    "more than one key",
+			data:    leaf.CertPEM + leaf.KeyPEM + other.KeyPEM,
+			wantErr: true,
+		},
+		{
+			name:    "no certificate",
+			data:    leaf.KeyPEM,
+			wantErr: true,
+		},
+		{
+			name:    "more than one leaf",
+			data:    leaf.CertPEM + other.CertPEM + intermediate.CertPEM + leaf.KeyPEM,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(testDir, "client.pem")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+
+			s := &Bundle{Path: path}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Bundle.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.ClientCertificateData != tt.wantCert {
+				t.Errorf("Bundle.Fetch() clientCertificateData = %v, want %v", got.ClientCertificateData, tt.wantCert)
+			}
+			if got.ClientKeyData != tt.wantKey {
+				t.Errorf("Bundle.Fetch() clientKeyData = %v, want %v", got.ClientKeyData, tt.wantKey)
+			}
+			if !got.ExpirationTimestamp.Equal(now.Add(time.Hour)) {
+				t.Errorf("Bundle.Fetch() expirationTimestamp = %v, want %v", got.ExpirationTimestamp, now.Add(time.Hour))
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/command"
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+type Command struct {
+	Slot    Slot
+	Cmdline string
+	// Timeout kills the command and its children if it does not finish in time. Zero means no timeout.
+	Timeout time.Duration
+}
+
+func init() {
+	Register("command", func(params Params) (Source, error) {
+		slot, err := parseSlot(params)
+		if err != nil {
+			return nil, err
+		}
+		if len(params["command"]) == 0 {
+			return nil, errors.New("command is required")
+		}
+
+		s := &Command{Slot: slot, Cmdline: params["command"]}
+		if len(params["timeout"]) > 0 {
+			d, err := time.ParseDuration(params["timeout"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %w", err)
+			}
+			s.Timeout = d
+		}
+
+		return s, nil
+	})
+}
+
+// Uncacheable is true, because the command decides on every run what to print, e.g. after a login in another terminal.
+func (s *Command) Uncacheable() bool {
+	return true
+}
+
+func (s *Command) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	c := &command.Command{
+		Cmdline: s.Cmdline,
+		Timeout: s.Timeout,
+		Stderr:  os.Stderr,
+	}
+	buf, err := c.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s command: %w", s.Slot, err)
+	}
+
+	return NewOption(s.Slot, string(buf))
+}
@@ -0,0 +1,61 @@
+package sources
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+func TestCommand_Fetch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell command is not supported on windows")
+	}
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			params: Params{"slot": "token", "command": "echo token-from-command"},
+			want:   &credentials.CredentialOption{Token: "token-from-command"},
+		},
+		{
+			name:    "failure",
+			params:  Params{"slot": "token", "command": "false"},
+			wantErr: true,
+		},
+		{
+			name:   "within timeout",
+			params: Params{"slot": "token", "command": "echo token-from-command", "timeout": "10s"},
+			want:   &credentials.CredentialOption{Token: "token-from-command"},
+		},
+		{
+			name:    "timeout",
+			params:  Params{"slot": "token", "command": "sleep 10", "timeout": "100ms"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New("command", tt.params)
+			if err != nil {
+				t.Errorf("New() error = %v", err)
+				return
+			}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Command.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Command.Fetch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package sources
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultCSRTimeout      = 5 * time.Minute
+	defaultCSRPollInterval = 2 * time.Second
+	csrGenerateName        = "credentials-broker-"
+)
+
+// CSR issues a client certificate with the CertificateSigningRequest API of the bootstrap cluster.
+// The key pair is generated locally, and the issued certificate and the key are written to CertificatePath and KeyPath.
+// They are reused until the certificate expires within RenewBefore.
+type CSR struct {
+	// Kubeconfig and Context select the bootstrap cluster and credential.
+	Kubeconfig string
+	Context    string
+	SignerName string
+	// Username and Groups are the subject of the certificate (CN and O).
+	Username string
+	Groups   []string
+	// Duration is requested with spec.expirationSeconds. The signer's default is used if 0.
+	Duration        time.Duration
+	CertificatePath string
+	KeyPath         string
+	RenewBefore     time.Duration
+	// Timeout is how long to wait for approval and issuance.
+	Timeout time.Duration
+
+	pollInterval time.Duration
+	now          func() time.Time
+}
+
+func init() {
+	Register("csr", func(params Params) (Source, error) {
+		s := &CSR{
+			Kubeconfig:      params["kubeconfig"],
+			Context:         params["context"],
+			SignerName:      params["signer-name"],
+			Username:        params["username"],
+			CertificatePath: params["certificate-path"],
+			KeyPath:         params["key-path"],
+		}
+		if len(params["groups"]) > 0 {
+			s.Groups = strings.Split(params["groups"], " ")
+		}
+		for key, d := range map[string]*time.Duration{"duration": &s.Duration, "renew-before": &s.RenewBefore, "timeout": &s.Timeout} {
+			if len(params[key]) == 0 {
+				continue
+			}
+			v, err := time.ParseDuration(params[key])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*d = v
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
+
+func (s *CSR) Validate() error {
+	switch {
+	case len(s.SignerName) == 0:
+		return errors.New("signer-name is required")
+	case len(s.Username) == 0:
+		return errors.New("username is required")
+	case len(s.CertificatePath) == 0 || len(s.KeyPath) == 0:
+		return errors.New("both certificate-path and key-path are required")
+	case s.Duration != 0 && s.Duration < minExpirationDuration:
+		return fmt.Errorf("duration must be %s or more", minExpirationDuration)
+	}
+
+	return nil
+}
+
+func (s *CSR) PrivateFiles() []string {
+	return []string{s.KeyPath}
+}
+
+func (s *CSR) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	if opts := readKeyPair(s.CertificatePath, s.KeyPath, s.RenewBefore, now()); opts != nil {
+		return opts, nil
+	}
+
+	opts, err := s.issue(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("csr: %w", err)
+	}
+	if err := writeKeyPair(s.CertificatePath, s.KeyPath, opts); err != nil {
+		return nil, fmt.Errorf("csr: failed to write the issued certificate: %w", err)
+	}
+
+	return opts, nil
+}
+
+func (s *CSR) issue(ctx context.Context) (*credentials.CredentialOption, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   s.Username,
+			Organization: s.Groups,
+		},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, _, err := newBootstrapClient(s.Kubeconfig, s.Context)
+	if err != nil {
+		return nil, err
+	}
+	csrClient := clientset.CertificatesV1().CertificateSigningRequests()
+
+	csr := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: csrGenerateName,
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+			SignerName: s.SignerName,
+			Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+		},
+	}
+	if s.Duration > 0 {
+		expirationSeconds := int32(s.Duration / time.Second)
+		csr.Spec.ExpirationSeconds = &expirationSeconds
+	}
+	csr, err = csrClient.Create(ctx, csr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	name := csr.Name
+	fmt.Fprintf(os.Stderr, "CertificateSigningRequest %s is submitted, waiting for approval and issuance\n", name)
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultCSRTimeout
+	}
+	pollInterval := s.pollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultCSRPollInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		csr, err = csrClient.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("CertificateSigningRequest %s is not issued in %s", name, timeout)
+			}
+			return nil, err
+		}
+		for _, c := range csr.Status.Conditions {
+			if (c.Type == certificatesv1.CertificateDenied || c.Type == certificatesv1.CertificateFailed) && c.Status == corev1.ConditionTrue {
+				return nil, fmt.Errorf("CertificateSigningRequest %s is %s: %s", name, strings.ToLower(string(c.Type)), c.Message)
+			}
+		}
+		if len(csr.Status.Certificate) > 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("CertificateSigningRequest %s is not issued in %s", name, timeout)
+		case <-time.After(pollInterval):
+		}
+	}
+
+	opts, err := NewOption(ClientCertificate, string(csr.Status.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	opts.ClientKeyData = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err := credentials.ValidateKeyPair(opts.ClientCertificateData, opts.ClientKeyData); err != nil {
+		return nil, fmt.Errorf("CertificateSigningRequest %s: %w", name, err)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,293 @@
+package sources
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCSR_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+
+	var (
+		submitted *certificatesv1.CertificateSigningRequest
+		polls     int
+		deny      bool
+		wrongKey  bool
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/apis/certificates.k8s.io/v1/certificatesigningrequests":
+			submitted = &certificatesv1.CertificateSigningRequest{}
+			if err := json.NewDecoder(r.Body).Decode(submitted); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			submitted.Name = submitted.GenerateName + "abcde"
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(submitted)
+		case r.Method == http.MethodGet && submitted != nil && r.URL.Path == "/apis/certificates.k8s.io/v1/certificatesigningrequests/"+submitted.Name:
+			polls++
+			res := submitted.DeepCopy()
+			switch {
+			case polls < 2:
+			case deny:
+				res.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{{Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue, Message: "not allowed"}}
+			default:
+				res.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue}}
+				res.Status.Certificate = signCSR(t, ca, res.Spec.Request, now.Add(time.Duration(*res.Spec.ExpirationSeconds)*time.Second))
+				if wrongKey {
+					res.Status.Certificate = []byte(ca.CertPEM)
+				}
+			}
+			json.NewEncoder(w).Encode(res)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+
+	kubeconfigPath := filepath.Join(testDir, "kubeconfig")
+	kubeconfig := fmt.Sprintf(`---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: %s
+    certificate-authority-data: %s
+  name: management
+contexts:
+- context:
+    cluster: management
+    user: bootstrap
+  name: context1
+users:
+- name: bootstrap
+  user:
+    token: bootstrap-token
+`, server.URL, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name        string
+		existing    *testutil.Certificate
+		deny        bool
+		wrongKey    bool
+		wantIssued  bool
+		wantExpires time.Time
+		wantErr     bool
+	}{
+		{
+			name:        "issue",
+			wantIssued:  true,
+			wantExpires: now.Add(2 * time.Hour),
+		},
+		{
+			name:        "reuse",
+			existing:    testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), ca),
+			wantExpires: now.Add(time.Hour),
+		},
+		{
+			name:        "renew",
+			existing:    testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Minute), ca),
+			wantIssued:  true,
+			wantExpires: now.Add(2 * time.Hour),
+		},
+		{
+			name:    "denied",
+			deny:    true,
+			wantErr: true,
+		},
+		{
+			name:     "certificate for another key",
+			existing: testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Minute), ca),
+			wrongKey: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			submitted, polls, deny, wrongKey = nil, 0, tt.deny, tt.wrongKey
+
+			certPath := filepath.Join(testDir, tt.name, "tls.crt")
+			keyPath := filepath.Join(testDir, tt.name, "tls.key")
+			if tt.existing != nil {
+				if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: tt.existing.CertPEM, ClientKeyData: tt.existing.KeyPEM}); err != nil {
+					t.Errorf("writeKeyPair() error = %v", err)
+					return
+				}
+			}
+
+			s := &CSR{
+				Kubeconfig:      kubeconfigPath,
+				SignerName:      "kubernetes.io/kube-apiserver-client",
+				Username:        "user1",
+				Groups:          []string{"group1", "group2"},
+				Duration:        2 * time.Hour,
+				CertificatePath: certPath,
+				KeyPath:         keyPath,
+				RenewBefore:     10 * time.Minute,
+				pollInterval:    time.Millisecond,
+				now:             func() time.Time { return now },
+			}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CSR.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if tt.existing != nil {
+					certPEM, _ := ioutil.ReadFile(certPath)
+					keyPEM, _ := ioutil.ReadFile(keyPath)
+					if string(certPEM) != tt.existing.CertPEM || string(keyPEM) != tt.existing.KeyPEM {
+						t.Errorf("CSR.Fetch() overwrote the existing key pair")
+					}
+				}
+				return
+			}
+
+			if (submitted != nil) != tt.wantIssued {
+				t.Errorf("CSR.Fetch() submitted = %v, want %v", submitted != nil, tt.wantIssued)
+			}
+			if tt.wantIssued {
+				if submitted.Spec.SignerName != "kubernetes.io/kube-apiserver-client" {
+					t.Errorf("CSR.Fetch() signerName = %v", submitted.Spec.SignerName)
+				}
+				req := parseCSR(t, submitted.Spec.Request)
+				if req.Subject.CommonName != "user1" || !reflect.DeepEqual(req.Subject.Organization, []string{"group1", "group2"}) {
+					t.Errorf("CSR.Fetch() subject = %v", req.Subject)
+				}
+			}
+			if !got.ExpirationTimestamp.Equal(tt.wantExpires) {
+				t.Errorf("CSR.Fetch() expirationTimestamp = %v, want %v", got.ExpirationTimestamp, tt.wantExpires)
+			}
+
+			certPEM, _ := ioutil.ReadFile(certPath)
+			keyPEM, _ := ioutil.ReadFile(keyPath)
+			if string(certPEM) != got.ClientCertificateData || string(keyPEM) != got.ClientKeyData {
+				t.Errorf("CSR.Fetch() did not write the returned key pair")
+			}
+			if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+				t.Errorf("CSR.Fetch() key pair mismatch: %v", err)
+			}
+		})
+	}
+}
+
+func parseCSR(t *testing.T, data []byte) *x509.CertificateRequest {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM-encoded CSR found")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificateRequest() error = %v", err)
+	}
+
+	return req
+}
+
+func signCSR(t *testing.T, ca *testutil.Certificate, data []byte, notAfter time.Time) []byte {
+	req := parseCSR(t, data)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      req.Subject,
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.Certificate, req.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCSR_Fetch_bootstrapUserRunsBroker(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	kubeconfigPath := filepath.Join(testDir, "kubeconfig")
+	kubeconfig := `---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: cluster1
+contexts:
+- context:
+    cluster: cluster1
+    user: user1
+  name: context1
+users:
+- name: user1
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      interactiveMode: IfAvailable
+      command: kubectl
+      args:
+      - credentials-broker
+      - --csr-signer-name
+      - kubernetes.io/kube-apiserver-client
+`
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	certPath := filepath.Join(testDir, "tls.crt")
+	s := &CSR{
+		Kubeconfig:      kubeconfigPath,
+		SignerName:      "kubernetes.io/kube-apiserver-client",
+		Username:        "user1",
+		CertificatePath: certPath,
+		KeyPath:         filepath.Join(testDir, "tls.key"),
+	}
+	if _, err := s.Fetch(context.Background()); err == nil {
+		t.Errorf("CSR.Fetch() error = nil, want an error for the bootstrap user running the broker")
+	}
+	if _, err := os.Stat(certPath); !os.IsNotExist(err) {
+		t.Errorf("CSR.Fetch() wrote %s", certPath)
+	}
+}
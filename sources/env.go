@@ -0,0 +1,43 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+type Env struct {
+	Slot Slot
+	Name string
+}
+
+func init() {
+	Register("env", func(params Params) (Source, error) {
+		slot, err := parseSlot(params)
+		if err != nil {
+			return nil, err
+		}
+		if len(params["name"]) == 0 {
+			return nil, errors.New("name is required")
+		}
+
+		return &Env{Slot: slot, Name: params["name"]}, nil
+	})
+}
+
+// Uncacheable is true, because each kubectl run can be given another value, which the cache would hide.
+func (s *Env) Uncacheable() bool {
+	return true
+}
+
+func (s *Env) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	v := os.Getenv(s.Name)
+	if len(v) == 0 {
+		return nil, fmt.Errorf("environment variable %s is not set", s.Name)
+	}
+
+	return NewOption(s.Slot, v)
+}
@@ -0,0 +1,50 @@
+package sources
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+func TestEnv_Fetch(t *testing.T) {
+	os.Setenv("TEST_SOURCES_TOKEN", "token-from-env")
+	defer os.Unsetenv("TEST_SOURCES_TOKEN")
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			params: Params{"slot": "token", "name": "TEST_SOURCES_TOKEN"},
+			want:   &credentials.CredentialOption{Token: "token-from-env"},
+		},
+		{
+			name:    "not set",
+			params:  Params{"slot": "token", "name": "TEST_SOURCES_UNSET"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New("env", tt.params)
+			if err != nil {
+				t.Errorf("New() error = %v", err)
+				return
+			}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Env.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Env.Fetch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+type File struct {
+	Slot Slot
+	Path string
+}
+
+func init() {
+	Register("file", func(params Params) (Source, error) {
+		slot, err := parseSlot(params)
+		if err != nil {
+			return nil, err
+		}
+		if len(params["path"]) == 0 {
+			return nil, errors.New("path is required")
+		}
+
+		return &File{Slot: slot, Path: params["path"]}, nil
+	})
+}
+
+// PrivateFiles returns the file unless it holds the certificate, which is public.
+func (s *File) PrivateFiles() []string {
+	if s.Slot == ClientCertificate {
+		return nil
+	}
+
+	return []string{s.Path}
+}
+
+func (s *File) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	buf, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOption(s.Slot, string(buf))
+}
@@ -0,0 +1,68 @@
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+func TestFile_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tokenPath := filepath.Join(testDir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("token-from-file\n"), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name    string
+		params  Params
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			params: Params{"slot": "token", "path": tokenPath},
+			want:   &credentials.CredentialOption{Token: "token-from-file"},
+		},
+		{
+			name:    "not found",
+			params:  Params{"slot": "token", "path": filepath.Join(testDir, "missing")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New("file", tt.params)
+			if err != nil {
+				t.Errorf("New() error = %v", err)
+				return
+			}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("File.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("File.Fetch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, params := range []Params{{"path": tokenPath}, {"slot": "token"}} {
+		if _, err := New("file", params); err == nil {
+			t.Errorf("New() with %v error = nil, want error", params)
+		}
+	}
+}
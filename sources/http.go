@@ -0,0 +1,264 @@
+package sources
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+const (
+	defaultHTTPField   = "token"
+	defaultHTTPTimeout = 30 * time.Second
+	httpHeaderParamKey = "header."
+)
+
+// HTTP fetches a credential from a JSON response of an HTTP(S) endpoint.
+type HTTP struct {
+	Slot    Slot
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+	// CAPath is a PEM-encoded CA bundle to verify the server. The system roots are used if empty.
+	CAPath string
+	// ClientCertificatePath and ClientKeyPath are used for mTLS.
+	ClientCertificatePath string
+	ClientKeyPath         string
+	// Field is the dot-separated path to the credential in the JSON response, e.g. 'status.token'.
+	Field string
+	// ExpirationField is the dot-separated path to the expiration in the JSON response.
+	// The value can be RFC 3339 string or UNIX time.
+	ExpirationField string
+	// ExpiresInField is the dot-separated path to the lifetime in seconds in the JSON response, e.g. 'expires_in'.
+	ExpiresInField string
+	Timeout        time.Duration
+
+	now func() time.Time
+}
+
+func init() {
+	Register("http", func(params Params) (Source, error) {
+		if len(params["slot"]) == 0 {
+			params["slot"] = string(Token)
+		}
+		slot, err := parseSlot(params)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &HTTP{
+			Slot:                  slot,
+			URL:                   params["url"],
+			Method:                params["method"],
+			Headers:               map[string]string{},
+			Body:                  params["body"],
+			CAPath:                params["ca-path"],
+			ClientCertificatePath: params["client-certificate-path"],
+			ClientKeyPath:         params["client-key-path"],
+			Field:                 params["field"],
+			ExpirationField:       params["expiration-field"],
+			ExpiresInField:        params["expires-in-field"],
+		}
+		for k, v := range params {
+			if strings.HasPrefix(k, httpHeaderParamKey) {
+				s.Headers[strings.TrimPrefix(k, httpHeaderParamKey)] = v
+			}
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
+
+func (s *HTTP) Validate() error {
+	if len(s.URL) == 0 {
+		return errors.New("url is required")
+	}
+	switch s.Method {
+	case "", http.MethodGet, http.MethodPost:
+	default:
+		return fmt.Errorf("method must be %s or %s", http.MethodGet, http.MethodPost)
+	}
+	if (len(s.ClientCertificatePath) > 0) != (len(s.ClientKeyPath) > 0) {
+		return errors.New("both client certificate and client key must be provided for mTLS")
+	}
+
+	return nil
+}
+
+func (s *HTTP) PrivateFiles() []string {
+	return []string{s.ClientKeyPath}
+}
+
+func (s *HTTP) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	client, err := newHTTPClient(s.CAPath, s.ClientCertificatePath, s.ClientKeyPath, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	method := s.Method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(s.Body) > 0 {
+		body = strings.NewReader(s.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(s.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, s.URL, res.Status, strings.TrimSpace(string(buf)))
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, fmt.Errorf("%s %s: invalid JSON response: %w", method, s.URL, err)
+	}
+
+	return s.parse(v)
+}
+
+func (s *HTTP) parse(v interface{}) (*credentials.CredentialOption, error) {
+	field := s.Field
+	if len(field) == 0 {
+		field = defaultHTTPField
+	}
+	data, ok := lookupField(v, field).(string)
+	if !ok || len(data) == 0 {
+		return nil, fmt.Errorf("field %s is not found in the response", field)
+	}
+
+	opts, err := NewOption(s.Slot, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.ExpirationField) > 0 {
+		t, err := parseTime(lookupField(v, s.ExpirationField))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", s.ExpirationField, err)
+		}
+		opts.UpdateExpirationTimestamp(t)
+	}
+
+	if len(s.ExpiresInField) > 0 {
+		expiresIn, ok := lookupField(v, s.ExpiresInField).(float64)
+		if !ok {
+			return nil, fmt.Errorf("field %s is not a number", s.ExpiresInField)
+		}
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		t := now().Add(time.Duration(expiresIn) * time.Second)
+		opts.UpdateExpirationTimestamp(&t)
+	}
+
+	return opts, nil
+}
+
+// newHTTPClient returns an HTTP client that verifies the server with the CA bundle, or the system roots if caPath is empty,
+// and authenticates with the client certificate if certPath is not empty.
+func newHTTPClient(caPath, certPath, keyPath string, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if len(caPath) > 0 {
+		buf, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no PEM-encoded certificate found in %s", caPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(certPath) > 0 {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+func lookupField(v interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+
+	return v
+}
+
+func parseTime(v interface{}) (*time.Time, error) {
+	switch t := v.(type) {
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return nil, err
+		}
+		return &parsed, nil
+	case float64:
+		parsed := time.Unix(int64(t), 0).UTC()
+		return &parsed, nil
+	default:
+		return nil, errors.New("not found or not a RFC 3339 string or UNIX time")
+	}
+}
@@ -0,0 +1,256 @@
+package sources
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestHTTP_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	client := testutil.GenerateCertificate(t, "client", now.Add(-time.Hour), now.Add(time.Hour), ca)
+	clientCertPath := filepath.Join(testDir, "client.crt")
+	clientKeyPath := filepath.Join(testDir, "client.key")
+	if err := ioutil.WriteFile(clientCertPath, []byte(client.CertPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(clientKeyPath, []byte(client.KeyPEM), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "client" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Cluster") != "cluster1" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "unknown cluster")
+			return
+		}
+
+		switch r.URL.Path {
+		case "/token":
+			fmt.Fprint(w, `{"token":"token-from-http","expirationTimestamp":"2021-04-01T12:00:00Z"}`)
+		case "/nested":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, `{"status":{"credentials":[{"token":"token-for-%s","exp":1617278400}]}}`, string(body))
+		case "/expires-in":
+			fmt.Fprint(w, `{"access_token":"token-from-http","expires_in":3600}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Certificate)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPath := filepath.Join(testDir, "server-ca.crt")
+	if err := ioutil.WriteFile(serverCAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	base := HTTP{
+		Slot:                  Token,
+		CAPath:                serverCAPath,
+		ClientCertificatePath: clientCertPath,
+		ClientKeyPath:         clientKeyPath,
+		Headers:               map[string]string{"X-Cluster": "cluster1"},
+		now:                   func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name    string
+		source  func() *HTTP
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name: "GET with expiration",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/token"
+				s.ExpirationField = "expirationTimestamp"
+				return &s
+			},
+			want: &credentials.CredentialOption{
+				Token:               "token-from-http",
+				ExpirationTimestamp: timePtr(time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name: "POST with nested field path",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/nested"
+				s.Method = http.MethodPost
+				s.Body = "user1"
+				s.Field = "status.credentials.0.token"
+				s.ExpirationField = "status.credentials.0.exp"
+				return &s
+			},
+			want: &credentials.CredentialOption{
+				Token:               "token-for-user1",
+				ExpirationTimestamp: timePtr(time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name: "expires_in",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/expires-in"
+				s.Field = "access_token"
+				s.ExpiresInField = "expires_in"
+				return &s
+			},
+			want: &credentials.CredentialOption{
+				Token:               "token-from-http",
+				ExpirationTimestamp: timePtr(now.Add(time.Hour)),
+			},
+		},
+		{
+			name: "field not found",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/token"
+				s.Field = "access_token"
+				return &s
+			},
+			wantErr: true,
+		},
+		{
+			name: "error status",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/token"
+				s.Headers = map[string]string{"X-Cluster": "cluster2"}
+				return &s
+			},
+			wantErr: true,
+		},
+		{
+			name: "without client certificate",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/token"
+				s.ClientCertificatePath = ""
+				s.ClientKeyPath = ""
+				return &s
+			},
+			wantErr: true,
+		},
+		{
+			name: "untrusted server",
+			source: func() *HTTP {
+				s := base
+				s.URL = server.URL + "/token"
+				s.CAPath = ""
+				return &s
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source().Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HTTP.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Token != tt.want.Token || !got.ExpirationTimestamp.Equal(*tt.want.ExpirationTimestamp) {
+				t.Errorf("HTTP.Fetch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTP_register(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		want    *HTTP
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			params: Params{"url": "https://example.com/token", "method": "POST", "header.Authorization": "Bearer xxx", "field": "access_token"},
+			want: &HTTP{
+				Slot:    Token,
+				URL:     "https://example.com/token",
+				Method:  "POST",
+				Headers: map[string]string{"Authorization": "Bearer xxx"},
+				Field:   "access_token",
+			},
+		},
+		{
+			name:    "url is required",
+			params:  Params{},
+			wantErr: true,
+		},
+		{
+			name:    "invalid method",
+			params:  Params{"url": "https://example.com/token", "method": "DELETE"},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate without key",
+			params:  Params{"url": "https://example.com/token", "client-certificate-path": "/path/to/tls.crt"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New("http", tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
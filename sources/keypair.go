@@ -0,0 +1,47 @@
+package sources
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/fileutil"
+)
+
+// readKeyPair reads the client certificate and key issued before.
+// It returns nil if they do not exist, or the certificate expires within renewBefore.
+func readKeyPair(certPath, keyPath string, renewBefore time.Duration, now time.Time) *credentials.CredentialOption {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil
+	}
+
+	opts := &credentials.CredentialOption{
+		ClientCertificateData: string(certPEM),
+		ClientKeyData:         string(keyPEM),
+	}
+	expirationTimestamp, err := credentials.CertificateExpirationTimestamp(opts.ClientCertificateData)
+	if err != nil || !now.Add(renewBefore).Before(*expirationTimestamp) {
+		return nil
+	}
+	opts.ExpirationTimestamp = expirationTimestamp
+
+	return opts
+}
+
+// writeKeyPair replaces the client certificate and key. Each file is replaced atomically, but not both at once:
+// when the key is rotated, a reader between the two renames sees the new key with the old certificate.
+// The key is not rewritten if it is unchanged, so that renewing the certificate alone leaves no such window.
+func writeKeyPair(certPath, keyPath string, opts *credentials.CredentialOption) error {
+	if current, err := ioutil.ReadFile(keyPath); err != nil || string(current) != opts.ClientKeyData {
+		if err := fileutil.WriteFileAtomic(keyPath, []byte(opts.ClientKeyData), 0600); err != nil {
+			return err
+		}
+	}
+
+	return fileutil.WriteFileAtomic(certPath, []byte(opts.ClientCertificateData), 0644)
+}
@@ -0,0 +1,115 @@
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func Test_readKeyPair(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	certPath := filepath.Join(testDir, "tls.crt")
+	keyPath := filepath.Join(testDir, "tls.key")
+
+	if got := readKeyPair(certPath, keyPath, 0, now); got != nil {
+		t.Errorf("readKeyPair() = %v, want nil when not found", got)
+	}
+
+	if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: cert.CertPEM, ClientKeyData: cert.KeyPEM}); err != nil {
+		t.Errorf("writeKeyPair() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name        string
+		renewBefore time.Duration
+		wantNil     bool
+	}{
+		{
+			name:        "valid",
+			renewBefore: 10 * time.Minute,
+		},
+		{
+			name:        "expires within renewBefore",
+			renewBefore: 2 * time.Hour,
+			wantNil:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readKeyPair(certPath, keyPath, tt.renewBefore, now)
+			if (got == nil) != tt.wantNil {
+				t.Errorf("readKeyPair() = %v, wantNil %v", got, tt.wantNil)
+				return
+			}
+			if got != nil && (got.ClientCertificateData != cert.CertPEM || got.ClientKeyData != cert.KeyPEM || !got.ExpirationTimestamp.Equal(now.Add(time.Hour))) {
+				t.Errorf("readKeyPair() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_writeKeyPair(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), ca)
+	renewed := testutil.GenerateCertificate(t, "user1", now, now.Add(2*time.Hour), ca)
+	certPath := filepath.Join(testDir, "tls.crt")
+	keyPath := filepath.Join(testDir, "tls.key")
+
+	if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: cert.CertPEM, ClientKeyData: cert.KeyPEM}); err != nil {
+		t.Errorf("writeKeyPair() error = %v", err)
+		return
+	}
+	before, err := os.Stat(keyPath)
+	if err != nil {
+		t.Errorf("os.Stat() error = %v", err)
+		return
+	}
+
+	// The certificate is renewed with the same key.
+	if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: renewed.CertPEM, ClientKeyData: cert.KeyPEM}); err != nil {
+		t.Errorf("writeKeyPair() error = %v", err)
+		return
+	}
+	after, err := os.Stat(keyPath)
+	if err != nil {
+		t.Errorf("os.Stat() error = %v", err)
+		return
+	}
+	if !os.SameFile(before, after) {
+		t.Errorf("writeKeyPair() replaced the unchanged key")
+	}
+	if buf, _ := ioutil.ReadFile(certPath); string(buf) != renewed.CertPEM {
+		t.Errorf("writeKeyPair() certificate = %v, want the renewed certificate", string(buf))
+	}
+
+	// The key is rotated.
+	if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: renewed.CertPEM, ClientKeyData: renewed.KeyPEM}); err != nil {
+		t.Errorf("writeKeyPair() error = %v", err)
+		return
+	}
+	if buf, _ := ioutil.ReadFile(keyPath); string(buf) != renewed.KeyPEM {
+		t.Errorf("writeKeyPair() key = %v, want the rotated key", string(buf))
+	}
+}
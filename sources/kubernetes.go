@@ -0,0 +1,72 @@
+package sources
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	pluginName = "credentials-broker"
+	// The apiserver rejects a TokenRequest or a CertificateSigningRequest with expirationSeconds less than 10 minutes.
+	minExpirationDuration = 10 * time.Minute
+)
+
+// newBootstrapClient returns the client of the cluster used to obtain credentials for another cluster.
+// The default kubeconfig loading rules are used if kubeconfigPath is empty, and the current context if context is empty.
+// It also returns the namespace of the context.
+func newBootstrapClient(kubeconfigPath, context string) (kubernetes.Interface, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: context})
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	// The current context usually belongs to the user whose exec plugin is this broker (see kubeconfig set).
+	// The nested broker would wait forever for the lock held by this process.
+	if runsBroker(restConfig.ExecProvider) {
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, "", err
+		}
+		name := rawConfig.CurrentContext
+		if len(context) > 0 {
+			name = context
+		}
+		return nil, "", fmt.Errorf("the user of context %q authenticates with %s itself, specify the context of another user for the bootstrap cluster", name, pluginName)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return clientset, namespace, nil
+}
+
+// runsBroker reports whether the exec plugin is this broker, run as "kubectl credentials-broker" or "kubectl-credentials-broker".
+func runsBroker(exec *clientcmdapi.ExecConfig) bool {
+	if exec == nil {
+		return false
+	}
+
+	name := strings.TrimSuffix(filepath.Base(exec.Command), ".exe")
+	switch name {
+	case "kubectl-" + pluginName:
+		return true
+	case "kubectl":
+		return len(exec.Args) > 0 && exec.Args[0] == pluginName
+	}
+
+	return false
+}
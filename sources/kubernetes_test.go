@@ -0,0 +1,119 @@
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_newBootstrapClient(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	kubeconfigPath := filepath.Join(testDir, "kubeconfig")
+	kubeconfig := `---
+apiVersion: v1
+kind: Config
+current-context: broker
+clusters:
+- cluster:
+    server: https://127.0.0.1
+  name: cluster1
+contexts:
+- context:
+    cluster: cluster1
+    user: broker
+  name: broker
+- context:
+    cluster: cluster1
+    user: plugin
+  name: plugin
+- context:
+    cluster: cluster1
+    user: other-plugin
+  name: other-plugin
+- context:
+    cluster: cluster1
+    user: token
+  name: token
+users:
+- name: broker
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      interactiveMode: IfAvailable
+      command: kubectl
+      args:
+      - credentials-broker
+      - --token-request-service-account
+      - default
+- name: plugin
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      interactiveMode: IfAvailable
+      command: /usr/local/bin/kubectl-credentials-broker
+- name: other-plugin
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      interactiveMode: IfAvailable
+      command: kubectl
+      args:
+      - oidc-login
+- name: token
+  user:
+    token: bootstrap-token
+`
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name    string
+		context string
+		wantErr bool
+	}{
+		{
+			name:    "current context runs the broker",
+			wantErr: true,
+		},
+		{
+			name:    "kubectl credentials-broker",
+			context: "broker",
+			wantErr: true,
+		},
+		{
+			name:    "kubectl-credentials-broker",
+			context: "plugin",
+			wantErr: true,
+		},
+		{
+			name:    "another exec plugin",
+			context: "other-plugin",
+		},
+		{
+			name:    "token",
+			context: "token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newBootstrapClient(kubeconfigPath, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newBootstrapClient() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "authenticates with "+pluginName) {
+				t.Errorf("newBootstrapClient() error = %v, want the error about the broker itself", err)
+			}
+		})
+	}
+}
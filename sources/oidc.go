@@ -0,0 +1,153 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/oidc"
+)
+
+// OIDC exchanges the stored refresh token for an ID token at the token endpoint of the issuer.
+// The refresh token rotated by the provider is written back to RefreshTokenPath.
+type OIDC struct {
+	IssuerURL        string
+	ClientID         string
+	ClientSecret     string
+	Scopes           []string
+	RefreshTokenPath string
+	// CAPath is a PEM-encoded CA bundle to verify the issuer. The system roots are used if empty.
+	CAPath string
+}
+
+// ErrLoginRequired is returned when there is no valid refresh token and the user has to log in.
+var ErrLoginRequired = errors.New("login required")
+
+func init() {
+	Register("oidc", func(params Params) (Source, error) {
+		s := &OIDC{
+			IssuerURL:        params["issuer-url"],
+			ClientID:         params["client-id"],
+			ClientSecret:     params["client-secret"],
+			RefreshTokenPath: params["refresh-token-path"],
+			CAPath:           params["ca-path"],
+		}
+		if len(params["scopes"]) > 0 {
+			s.Scopes = strings.Split(params["scopes"], " ")
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
+
+func (s *OIDC) Validate() error {
+	switch {
+	case len(s.IssuerURL) == 0:
+		return errors.New("issuer-url is required")
+	case len(s.ClientID) == 0:
+		return errors.New("client-id is required")
+	case len(s.RefreshTokenPath) == 0:
+		return errors.New("refresh-token-path is required")
+	}
+
+	return nil
+}
+
+// Client returns the OIDC client for the issuer.
+func (s *OIDC) Client() (*oidc.Client, error) {
+	httpClient, err := newHTTPClient(s.CAPath, "", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.Client{
+		IssuerURL:    s.IssuerURL,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Scopes:       s.Scopes,
+		HTTPClient:   httpClient,
+	}, nil
+}
+
+func (s *OIDC) PrivateFiles() []string {
+	return []string{s.RefreshTokenPath}
+}
+
+func (s *OIDC) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	refreshToken, err := oidc.ReadRefreshToken(s.RefreshTokenPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("oidc: %w: refresh token is not found in %s", ErrLoginRequired, s.RefreshTokenPath)
+		}
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+
+	client, err := s.Client()
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+	provider, err := client.Discover(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+	token, err := client.Refresh(ctx, provider, refreshToken)
+	if err != nil {
+		var e *oidc.Error
+		if errors.As(err, &e) && e.Code == "invalid_grant" {
+			return nil, fmt.Errorf("oidc: %w: refresh token is expired or revoked: %s", ErrLoginRequired, e)
+		}
+		return nil, fmt.Errorf("oidc: refresh failed: %w", err)
+	}
+
+	if len(token.RefreshToken) > 0 && token.RefreshToken != refreshToken {
+		if err := oidc.WriteRefreshToken(s.RefreshTokenPath, token.RefreshToken); err != nil {
+			return nil, fmt.Errorf("oidc: failed to store the rotated refresh token: %w", err)
+		}
+	}
+
+	if len(token.IDToken) == 0 {
+		return nil, errors.New("oidc: id_token is not found in the token response")
+	}
+
+	return NewOption(Token, token.IDToken)
+}
+
+// Login performs the device authorization grant and stores the refresh token.
+// The verification URL and the user code are written to w.
+func (s *OIDC) Login(ctx context.Context, w io.Writer) error {
+	client, err := s.Client()
+	if err != nil {
+		return err
+	}
+	provider, err := client.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+	da, err := client.AuthorizeDevice(ctx, provider)
+	if err != nil {
+		return fmt.Errorf("device authorization failed: %w", err)
+	}
+
+	if len(da.VerificationURIComplete) > 0 {
+		fmt.Fprintf(w, "Open %s to log in, and confirm the code: %s\n", da.VerificationURIComplete, da.UserCode)
+	} else {
+		fmt.Fprintf(w, "Open %s to log in, and enter the code: %s\n", da.VerificationURI, da.UserCode)
+	}
+
+	token, err := client.PollDeviceToken(ctx, provider, da)
+	if err != nil {
+		return fmt.Errorf("device authorization failed: %w", err)
+	}
+	if len(token.RefreshToken) == 0 {
+		return errors.New("refresh token is not returned, the offline_access scope may be required")
+	}
+
+	return oidc.WriteRefreshToken(s.RefreshTokenPath, token.RefreshToken)
+}
@@ -0,0 +1,188 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestOIDC_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	exp := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	idToken := testutil.GenerateJWT(t, map[string]interface{}{"exp": exp.Unix()})
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer":%q,"token_endpoint":%q}`, server.URL, server.URL+"/token")
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.PostForm.Get("refresh_token") {
+		case "refresh-token1":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id_token": idToken, "refresh_token": "refresh-token2"})
+		case "refresh-token2":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id_token": idToken})
+		case "without-id-token":
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access-token"})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+		}
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name             string
+		refreshToken     string
+		wantRefreshToken string
+		wantErr          bool
+		wantLogin        bool
+	}{
+		{
+			name:             "rotated",
+			refreshToken:     "refresh-token1",
+			wantRefreshToken: "refresh-token2",
+		},
+		{
+			name:             "not rotated",
+			refreshToken:     "refresh-token2",
+			wantRefreshToken: "refresh-token2",
+		},
+		{
+			name:             "without id_token",
+			refreshToken:     "without-id-token",
+			wantRefreshToken: "without-id-token",
+			wantErr:          true,
+		},
+		{
+			name:             "invalid grant",
+			refreshToken:     "refresh-token0",
+			wantRefreshToken: "refresh-token0",
+			wantErr:          true,
+			wantLogin:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(testDir, "refresh-token")
+			if err := ioutil.WriteFile(path, []byte(tt.refreshToken+"\n"), 0600); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+
+			s := &OIDC{IssuerURL: server.URL, ClientID: "client1", RefreshTokenPath: path}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if errors.Is(err, ErrLoginRequired) != tt.wantLogin {
+				t.Errorf("OIDC.Fetch() error = %v, wantLogin %v", err, tt.wantLogin)
+			}
+			if !tt.wantErr && (got.Token != idToken || !got.ExpirationTimestamp.Equal(exp)) {
+				t.Errorf("OIDC.Fetch() = %+v, want token %s expiring at %s", got, idToken, exp)
+			}
+
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Errorf("ioutil.ReadFile() error = %v", err)
+				return
+			}
+			if string(buf) != tt.wantRefreshToken+"\n" {
+				t.Errorf("stored refresh token = %q, want %q", string(buf), tt.wantRefreshToken)
+			}
+		})
+	}
+
+	t.Run("refresh token not found", func(t *testing.T) {
+		s := &OIDC{IssuerURL: server.URL, ClientID: "client1", RefreshTokenPath: filepath.Join(testDir, "not-found")}
+		if _, err := s.Fetch(context.Background()); !errors.Is(err, ErrLoginRequired) {
+			t.Errorf("OIDC.Fetch() error = %v, want %v", err, ErrLoginRequired)
+		}
+	})
+}
+
+func TestOIDC_Login(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	tests := []struct {
+		name     string
+		response string
+		wantErr  bool
+	}{
+		{
+			name:     "ok",
+			response: `{"id_token":"id-token1","refresh_token":"refresh-token1"}`,
+		},
+		{
+			name:     "without refresh token",
+			response: `{"id_token":"id-token1"}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			mux := http.NewServeMux()
+			mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"issuer":%q,"token_endpoint":%q,"device_authorization_endpoint":%q}`, server.URL, server.URL+"/token", server.URL+"/device")
+			})
+			mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"device_code":"device-code1","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600,"interval":1}`)
+			})
+			mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.response)
+			})
+			server = httptest.NewServer(mux)
+			defer server.Close()
+
+			path := filepath.Join(testDir, tt.name, "refresh-token")
+			s := &OIDC{IssuerURL: server.URL, ClientID: "client1", RefreshTokenPath: path}
+			w := &strings.Builder{}
+			err := s.Login(context.Background(), w)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.Login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !strings.Contains(w.String(), "https://example.com/device") || !strings.Contains(w.String(), "ABCD-EFGH") {
+				t.Errorf("OIDC.Login() wrote %q, want the verification URI and the user code", w.String())
+			}
+			if tt.wantErr {
+				return
+			}
+
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Errorf("ioutil.ReadFile() error = %v", err)
+				return
+			}
+			if string(buf) != "refresh-token1\n" {
+				t.Errorf("stored refresh token = %q, want %q", string(buf), "refresh-token1")
+			}
+		})
+	}
+}
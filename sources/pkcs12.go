@@ -0,0 +1,86 @@
+package sources
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PKCS12 reads the client certificate, the intermediate certificates and the key from a PKCS#12 (PFX) file.
+type PKCS12 struct {
+	Path string
+	// Passphrase is called when the file is protected by a non-empty passphrase.
+	Passphrase func() ([]byte, error)
+}
+
+func init() {
+	Register("pkcs12", func(params Params) (Source, error) {
+		if len(params["path"]) == 0 {
+			return nil, errors.New("path is required")
+		}
+		s := &PKCS12{Path: params["path"]}
+		if name := params["passphrase-env"]; len(name) > 0 {
+			s.Passphrase = func() ([]byte, error) {
+				v, ok := os.LookupEnv(name)
+				if !ok {
+					return nil, fmt.Errorf("environment variable %s is not set", name)
+				}
+				return []byte(v), nil
+			}
+		}
+
+		return s, nil
+	})
+}
+
+// Uncacheable is true, because the cache would store the key in the clear, while the file may protect it with a passphrase.
+func (s *PKCS12) Uncacheable() bool {
+	return true
+}
+
+func (s *PKCS12) PrivateFiles() []string {
+	return []string{s.Path}
+}
+
+func (s *PKCS12) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, "")
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) && s.Passphrase != nil {
+		passphrase, perr := s.Passphrase()
+		if perr != nil {
+			return nil, fmt.Errorf("pkcs12: %w", perr)
+		}
+		key, cert, caCerts, err = pkcs12.DecodeChain(data, string(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: %s: %w", s.Path, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	for _, c := range caCerts {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: %s: %w", s.Path, err)
+	}
+
+	opts, err := NewOption(ClientCertificate, string(certPEM))
+	if err != nil {
+		return nil, err
+	}
+	opts.ClientKeyData = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	return opts, nil
+}
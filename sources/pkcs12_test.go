@@ -0,0 +1,98 @@
+package sources
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestPKCS12_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	leaf := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), ca)
+
+	tests := []struct {
+		name       string
+		password   string
+		passphrase func() ([]byte, error)
+		wantErr    bool
+	}{
+		{
+			name:     "without passphrase",
+			password: "",
+		},
+		{
+			name:       "with passphrase",
+			password:   "passphrase",
+			passphrase: func() ([]byte, error) { return []byte("passphrase"), nil },
+		},
+		{
+			name:       "incorrect passphrase",
+			password:   "passphrase",
+			passphrase: func() ([]byte, error) { return []byte("incorrect"), nil },
+			wantErr:    true,
+		},
+		{
+			name:     "passphrase is not provided",
+			password: "passphrase",
+			wantErr:  true,
+		},
+		{
+			name:       "failed to read passphrase",
+			password:   "passphrase",
+			passphrase: func() ([]byte, error) { return nil, errors.New("not interactive") },
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pfx, err := pkcs12.Encode(rand.Reader, leaf.PrivateKey, leaf.Certificate, []*x509.Certificate{ca.Certificate}, tt.password)
+			if err != nil {
+				t.Errorf("pkcs12.Encode() error = %v", err)
+				return
+			}
+			path := filepath.Join(testDir, "client.p12")
+			if err := ioutil.WriteFile(path, pfx, 0600); err != nil {
+				t.Errorf("ioutil.WriteFile() error = %v", err)
+				return
+			}
+
+			s := &PKCS12{Path: path, Passphrase: tt.passphrase}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS12.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.ClientCertificateData != leaf.CertPEM+ca.CertPEM {
+				t.Errorf("PKCS12.Fetch() clientCertificateData = %v, want leaf and CA certificates", got.ClientCertificateData)
+			}
+			if _, err := tls.X509KeyPair([]byte(got.ClientCertificateData), []byte(got.ClientKeyData)); err != nil {
+				t.Errorf("PKCS12.Fetch() key pair mismatch: %v", err)
+			}
+			if !got.ExpirationTimestamp.Equal(now.Add(time.Hour)) {
+				t.Errorf("PKCS12.Fetch() expirationTimestamp = %v, want %v", got.ExpirationTimestamp, now.Add(time.Hour))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+// Renew renews the client certificate at an endpoint of the CA, authenticating with the current certificate and key.
+// The renewed certificate and key replace CertificatePath and KeyPath (see writeKeyPair) once they are confirmed to match.
+//
+// The response is either PEM-encoded certificates (and a private key when the key is rotated),
+// or a JSON object like step-ca: {"crt": "...", "ca": "...", "certChain": ["...", "..."], "key": "..."}.
+// The current key is kept if the response does not contain a key.
+type Renew struct {
+	URL             string
+	CertificatePath string
+	KeyPath         string
+	// CAPath is a PEM-encoded CA bundle to verify the endpoint. The system roots are used if empty.
+	CAPath      string
+	RenewBefore time.Duration
+	Timeout     time.Duration
+
+	now func() time.Time
+}
+
+type renewResponse struct {
+	Certificate      string   `json:"crt"`
+	CACertificate    string   `json:"ca"`
+	CertificateChain []string `json:"certChain"`
+	Key              string   `json:"key"`
+}
+
+func init() {
+	Register("renew", func(params Params) (Source, error) {
+		s := &Renew{
+			URL:             params["url"],
+			CertificatePath: params["certificate-path"],
+			KeyPath:         params["key-path"],
+			CAPath:          params["ca-path"],
+		}
+		if len(params["renew-before"]) > 0 {
+			d, err := time.ParseDuration(params["renew-before"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid renew-before: %w", err)
+			}
+			s.RenewBefore = d
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
+
+func (s *Renew) Validate() error {
+	switch {
+	case len(s.URL) == 0:
+		return errors.New("url is required")
+	case len(s.CertificatePath) == 0 || len(s.KeyPath) == 0:
+		return errors.New("both certificate-path and key-path are required")
+	}
+
+	return nil
+}
+
+func (s *Renew) PrivateFiles() []string {
+	return []string{s.KeyPath}
+}
+
+func (s *Renew) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	if opts := readKeyPair(s.CertificatePath, s.KeyPath, s.RenewBefore, now()); opts != nil {
+		return opts, nil
+	}
+
+	for _, path := range []string{s.CertificatePath, s.KeyPath} {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("renew: the current certificate and key are required to renew: %w", err)
+		}
+	}
+
+	opts, err := s.renew(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("renew: %w", err)
+	}
+	if err := writeKeyPair(s.CertificatePath, s.KeyPath, opts); err != nil {
+		return nil, fmt.Errorf("renew: failed to write the renewed certificate: %w", err)
+	}
+
+	return opts, nil
+}
+
+func (s *Renew) renew(ctx context.Context) (*credentials.CredentialOption, error) {
+	client, err := newHTTPClient(s.CAPath, s.CertificatePath, s.KeyPath, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w (an expired certificate can not be renewed, issue a new one)", err)
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("POST %s: %s: %s", s.URL, res.Status, strings.TrimSpace(string(buf)))
+	}
+
+	certPEM, keyPEM, err := parseRenewResponse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("POST %s: %w", s.URL, err)
+	}
+	if len(keyPEM) == 0 {
+		current, err := ioutil.ReadFile(s.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = string(current)
+	}
+
+	opts, err := NewOption(ClientCertificate, certPEM)
+	if err != nil {
+		return nil, err
+	}
+	opts.ClientKeyData = keyPEM
+	// The current key pair is kept if the CA returns a certificate for another key.
+	if err := credentials.ValidateKeyPair(opts.ClientCertificateData, opts.ClientKeyData); err != nil {
+		return nil, fmt.Errorf("POST %s: %w", s.URL, err)
+	}
+
+	return opts, nil
+}
+
+func parseRenewResponse(buf []byte) (string, string, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "{") {
+		res := &renewResponse{}
+		if err := json.Unmarshal(buf, res); err != nil {
+			return "", "", fmt.Errorf("invalid JSON response: %w", err)
+		}
+
+		data := res.Certificate + res.CACertificate
+		if len(res.CertificateChain) > 0 {
+			data = strings.Join(res.CertificateChain, "")
+		}
+		certPEM, _ := credentials.SplitPEM(data)
+		if len(certPEM) == 0 {
+			return "", "", errors.New("no certificate found in the response")
+		}
+		return certPEM, res.Key, nil
+	}
+
+	certPEM, keyPEM := credentials.SplitPEM(string(buf))
+	if len(certPEM) == 0 {
+		return "", "", errors.New("no certificate found in the response")
+	}
+
+	return certPEM, keyPEM, nil
+}
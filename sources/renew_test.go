@@ -0,0 +1,205 @@
+package sources
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestRenew_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	now := time.Now().Truncate(time.Second)
+	ca := testutil.GenerateCertificate(t, "ca", now.Add(-time.Hour), now.Add(24*time.Hour), nil)
+	rotatedKey := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(2*time.Hour), ca)
+	renewedNotAfter := now.Add(2 * time.Hour)
+
+	renewals := 0
+	mux := http.NewServeMux()
+	renewed := func(r *http.Request) string {
+		renewals++
+		peer := r.TLS.PeerCertificates[0]
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      peer.Subject,
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     renewedNotAfter,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca.Certificate, peer.PublicKey, ca.PrivateKey)
+		if err != nil {
+			t.Fatalf("x509.CreateCertificate() error = %v", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	mux.HandleFunc("/pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(renewed(r) + ca.CertPEM))
+	})
+	mux.HandleFunc("/step-ca", func(w http.ResponseWriter, r *http.Request) {
+		crt := renewed(r)
+		json.NewEncoder(w).Encode(map[string]interface{}{"crt": crt, "ca": ca.CertPEM, "certChain": []string{crt, ca.CertPEM}})
+	})
+	mux.HandleFunc("/rekey", func(w http.ResponseWriter, r *http.Request) {
+		renewals++
+		w.Write([]byte(rotatedKey.CertPEM + rotatedKey.KeyPEM))
+	})
+	mux.HandleFunc("/rekey-without-key", func(w http.ResponseWriter, r *http.Request) {
+		renewals++
+		w.Write([]byte(rotatedKey.CertPEM))
+	})
+	mux.HandleFunc("/wrong-key", func(w http.ResponseWriter, r *http.Request) {
+		renewals++
+		w.Write([]byte(rotatedKey.CertPEM + ca.KeyPEM))
+	})
+	server := httptest.NewUnstartedServer(mux)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Certificate)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPath := filepath.Join(testDir, "server-ca.crt")
+	if err := ioutil.WriteFile(serverCAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		current      *testutil.Certificate
+		wantRenewed  bool
+		wantRotated  bool
+		wantNotAfter time.Time
+		wantErr      bool
+	}{
+		{
+			name:         "not expiring",
+			path:         "/pem",
+			current:      testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), ca),
+			wantNotAfter: now.Add(time.Hour),
+		},
+		{
+			name:         "PEM response",
+			path:         "/pem",
+			current:      testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(5*time.Minute), ca),
+			wantRenewed:  true,
+			wantNotAfter: renewedNotAfter,
+		},
+		{
+			name:         "step-ca response",
+			path:         "/step-ca",
+			current:      testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(5*time.Minute), ca),
+			wantRenewed:  true,
+			wantNotAfter: renewedNotAfter,
+		},
+		{
+			name:         "key rotated",
+			path:         "/rekey",
+			current:      testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(5*time.Minute), ca),
+			wantRenewed:  true,
+			wantRotated:  true,
+			wantNotAfter: now.Add(2 * time.Hour),
+		},
+		{
+			name:    "rotated key not returned",
+			path:    "/rekey-without-key",
+			current: testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(5*time.Minute), ca),
+			wantErr: true,
+		},
+		{
+			name:    "mismatched key",
+			path:    "/wrong-key",
+			current: testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(5*time.Minute), ca),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			path:    "/pem",
+			current: testutil.GenerateCertificate(t, "user1", now.Add(-2*time.Hour), now.Add(-time.Hour), ca),
+			wantErr: true,
+		},
+		{
+			name:    "not issued yet",
+			path:    "/pem",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renewals = 0
+			certPath := filepath.Join(testDir, tt.name, "tls.crt")
+			keyPath := filepath.Join(testDir, tt.name, "tls.key")
+			if tt.current != nil {
+				if err := writeKeyPair(certPath, keyPath, &credentials.CredentialOption{ClientCertificateData: tt.current.CertPEM, ClientKeyData: tt.current.KeyPEM}); err != nil {
+					t.Errorf("writeKeyPair() error = %v", err)
+					return
+				}
+			}
+
+			s := &Renew{
+				URL:             server.URL + tt.path,
+				CertificatePath: certPath,
+				KeyPath:         keyPath,
+				CAPath:          serverCAPath,
+				RenewBefore:     10 * time.Minute,
+				now:             func() time.Time { return now },
+			}
+			got, err := s.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Renew.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if tt.current != nil {
+					certPEM, _ := ioutil.ReadFile(certPath)
+					keyPEM, _ := ioutil.ReadFile(keyPath)
+					if string(certPEM) != tt.current.CertPEM || string(keyPEM) != tt.current.KeyPEM {
+						t.Errorf("Renew.Fetch() overwrote the current key pair")
+					}
+				}
+				return
+			}
+
+			if (renewals > 0) != tt.wantRenewed {
+				t.Errorf("Renew.Fetch() renewed = %v, want %v", renewals > 0, tt.wantRenewed)
+			}
+			if !got.ExpirationTimestamp.Equal(tt.wantNotAfter) {
+				t.Errorf("Renew.Fetch() expirationTimestamp = %v, want %v", got.ExpirationTimestamp, tt.wantNotAfter)
+			}
+			if (got.ClientKeyData != tt.current.KeyPEM) != tt.wantRotated {
+				t.Errorf("Renew.Fetch() key rotated = %v, want %v", got.ClientKeyData != tt.current.KeyPEM, tt.wantRotated)
+			}
+
+			certPEM, _ := ioutil.ReadFile(certPath)
+			keyPEM, _ := ioutil.ReadFile(keyPath)
+			if string(certPEM) != got.ClientCertificateData || string(keyPEM) != got.ClientKeyData {
+				t.Errorf("Renew.Fetch() did not write the returned key pair")
+			}
+			if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+				t.Errorf("Renew.Fetch() key pair mismatch: %v", err)
+			}
+		})
+	}
+}
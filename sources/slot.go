@@ -0,0 +1,60 @@
+package sources
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+// Slot is the kind of credential provided by a source.
+type Slot string
+
+const (
+	ClientCertificate Slot = "client-certificate"
+	ClientKey         Slot = "client-key"
+	Token             Slot = "token"
+)
+
+func parseSlot(params Params) (Slot, error) {
+	switch slot := Slot(params["slot"]); slot {
+	case ClientCertificate, ClientKey, Token:
+		return slot, nil
+	default:
+		return "", fmt.Errorf("slot must be one of %s, %s, %s", ClientCertificate, ClientKey, Token)
+	}
+}
+
+// NewOption returns the CredentialOption in which the data is set to the slot,
+// with the expiration timestamp of the certificate or the JWT token.
+func NewOption(slot Slot, data string) (*credentials.CredentialOption, error) {
+	opts := &credentials.CredentialOption{}
+
+	switch slot {
+	case ClientCertificate:
+		expirationTimestamp, err := credentials.CertificateExpirationTimestamp(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		opts.ClientCertificateData = data
+		opts.ExpirationTimestamp = expirationTimestamp
+	case ClientKey:
+		opts.ClientKeyData = data
+	case Token:
+		opts.Token = chop(data)
+		opts.ExpirationTimestamp = credentials.TokenExpirationTimestamp(opts.Token)
+	default:
+		return nil, fmt.Errorf("unknown slot: %s", slot)
+	}
+
+	return opts, nil
+}
+
+func chop(s string) string {
+	s = strings.TrimRight(s, "\n")
+	if strings.HasSuffix(s, "\r") {
+		s = strings.TrimRight(s, "\r")
+	}
+
+	return s
+}
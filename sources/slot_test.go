@@ -0,0 +1,115 @@
+package sources
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	"github.com/takumakume/kubectl-credentials-broker/internal/testutil"
+)
+
+func TestNewOption(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cert := testutil.GenerateCertificate(t, "user1", now.Add(-time.Hour), now.Add(time.Hour), nil)
+	jwt := testutil.GenerateJWT(t, map[string]interface{}{"exp": now.Add(30 * time.Minute).Unix()})
+	jwtExpirationTimestamp := now.Add(30 * time.Minute).UTC()
+
+	type args struct {
+		slot Slot
+		data string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name: "client certificate",
+			args: args{slot: ClientCertificate, data: cert.CertPEM},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: cert.CertPEM,
+				ExpirationTimestamp:   &cert.Certificate.NotAfter,
+			},
+		},
+		{
+			name:    "invalid client certificate",
+			args:    args{slot: ClientCertificate, data: "client-certificate"},
+			wantErr: true,
+		},
+		{
+			name: "client key",
+			args: args{slot: ClientKey, data: cert.KeyPEM},
+			want: &credentials.CredentialOption{
+				ClientKeyData: cert.KeyPEM,
+			},
+		},
+		{
+			name: "token",
+			args: args{slot: Token, data: "token\n"},
+			want: &credentials.CredentialOption{
+				Token: "token",
+			},
+		},
+		{
+			name: "JWT token",
+			args: args{slot: Token, data: jwt + "\n"},
+			want: &credentials.CredentialOption{
+				Token:               jwt,
+				ExpirationTimestamp: &jwtExpirationTimestamp,
+			},
+		},
+		{
+			name:    "unknown slot",
+			args:    args{slot: Slot("password"), data: "password"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewOption(tt.args.slot, tt.args.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewOption() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewOption() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_chop(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "remove last return code",
+			args: args{s: "string\n"},
+			want: "string",
+		},
+		{
+			name: "remove only last return code",
+			args: args{s: "string1\nstring2\n"},
+			want: "string1\nstring2",
+		},
+		{
+			name: "remove CR",
+			args: args{s: "string\r\n"},
+			want: "string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chop(tt.args.s); got != tt.want {
+				t.Errorf("chop() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
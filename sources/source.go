@@ -0,0 +1,139 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+// Source fetches a part of the credentials, e.g. only the token.
+// The expiration timestamp of the fetched credentials is set in the returned CredentialOption.
+type Source interface {
+	Fetch(ctx context.Context) (*credentials.CredentialOption, error)
+}
+
+// Uncacheable is implemented by sources whose credentials must not be cached.
+type Uncacheable interface {
+	Uncacheable() bool
+}
+
+// Cacheable reports whether the credentials fetched from the sources can be cached.
+func Cacheable(sources []Source) bool {
+	for _, s := range sources {
+		if u, ok := s.(Uncacheable); ok && u.Uncacheable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Private is implemented by sources which read secrets from files that must not be accessible by others.
+type Private interface {
+	PrivateFiles() []string
+}
+
+// PrivateFiles returns the files holding the secrets read by the sources.
+func PrivateFiles(sources []Source) []string {
+	files := []string{}
+	for _, s := range sources {
+		if p, ok := s.(Private); ok {
+			for _, path := range p.PrivateFiles() {
+				if len(path) > 0 {
+					files = append(files, path)
+				}
+			}
+		}
+	}
+
+	return files
+}
+
+type Params map[string]string
+
+type Factory func(params Params) (Source, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a source available by name. Embedders of the broker can register custom sources.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("source %s is already registered", name))
+	}
+	factories[name] = factory
+}
+
+func New(name string, params Params) (Source, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source: %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	return factory(params)
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parse parses a source specification in the form of 'name:key1=value1,key2=value2'.
+func Parse(spec string) (string, Params, error) {
+	name, rest := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, rest = spec[:i], spec[i+1:]
+	}
+	if len(name) == 0 {
+		return "", nil, fmt.Errorf("source name is empty: %s", spec)
+	}
+
+	params := Params{}
+	if len(rest) == 0 {
+		return name, params, nil
+	}
+	for _, kv := range strings.Split(rest, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return "", nil, fmt.Errorf("invalid source parameter: %s", kv)
+		}
+		params[kv[:i]] = kv[i+1:]
+	}
+
+	return name, params, nil
+}
+
+// FetchAll fetches all sources and merges the results.
+func FetchAll(ctx context.Context, sources []Source) (*credentials.CredentialOption, error) {
+	opts := &credentials.CredentialOption{}
+	for _, s := range sources {
+		o, err := s.Fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.Merge(o); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
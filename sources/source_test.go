@@ -0,0 +1,233 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+)
+
+type staticSource struct {
+	opts *credentials.CredentialOption
+	err  error
+}
+
+func (s *staticSource) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	return s.opts, s.err
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-static", func(params Params) (Source, error) {
+		return &staticSource{opts: &credentials.CredentialOption{Token: params["token"]}}, nil
+	})
+
+	s, err := New("test-static", Params{"token": "token-from-static"})
+	if err != nil {
+		t.Errorf("New() error = %v", err)
+		return
+	}
+	got, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Errorf("Fetch() error = %v", err)
+		return
+	}
+	if got.Token != "token-from-static" {
+		t.Errorf("Fetch() = %+v, want token %v", got, "token-from-static")
+	}
+
+	if _, err := New("test-unknown", Params{}); err == nil {
+		t.Errorf("New() with unknown source error = nil, want error")
+	}
+}
+
+func TestParse(t *testing.T) {
+	type args struct {
+		spec string
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantName   string
+		wantParams Params
+		wantErr    bool
+	}{
+		{
+			name:       "with params",
+			args:       args{spec: "file:slot=token,path=/path/to/token"},
+			wantName:   "file",
+			wantParams: Params{"slot": "token", "path": "/path/to/token"},
+		},
+		{
+			name:       "value contains '='",
+			args:       args{spec: "command:slot=token,command=print-token --format=raw"},
+			wantName:   "command",
+			wantParams: Params{"slot": "token", "command": "print-token --format=raw"},
+		},
+		{
+			name:       "without params",
+			args:       args{spec: "keyring"},
+			wantName:   "keyring",
+			wantParams: Params{},
+		},
+		{
+			name:    "empty name",
+			args:    args{spec: ":slot=token"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid param",
+			args:    args{spec: "file:slot"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotName, gotParams, err := Parse(tt.args.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotName != tt.wantName {
+				t.Errorf("Parse() name = %v, want %v", gotName, tt.wantName)
+			}
+			if !reflect.DeepEqual(gotParams, tt.wantParams) {
+				t.Errorf("Parse() params = %v, want %v", gotParams, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		want    *credentials.CredentialOption
+		wantErr bool
+	}{
+		{
+			name: "merge",
+			sources: []Source{
+				&staticSource{opts: &credentials.CredentialOption{ClientCertificateData: "cert"}},
+				&staticSource{opts: &credentials.CredentialOption{ClientKeyData: "key"}},
+			},
+			want: &credentials.CredentialOption{
+				ClientCertificateData: "cert",
+				ClientKeyData:         "key",
+			},
+		},
+		{
+			name: "conflict",
+			sources: []Source{
+				&staticSource{opts: &credentials.CredentialOption{Token: "token1"}},
+				&staticSource{opts: &credentials.CredentialOption{Token: "token2"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error",
+			sources: []Source{
+				&staticSource{err: errors.New("failed")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FetchAll(context.Background(), tt.sources)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FetchAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FetchAll() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		want    bool
+	}{
+		{
+			name:    "file",
+			sources: []Source{&File{Slot: Token, Path: "/path/to/token"}},
+			want:    true,
+		},
+		{
+			name:    "env",
+			sources: []Source{&File{Slot: ClientCertificate, Path: "/path/to/tls.crt"}, &Env{Slot: ClientKey, Name: "CLIENT_KEY"}},
+			want:    false,
+		},
+		{
+			name:    "command",
+			sources: []Source{&Command{Slot: Token, Cmdline: "print-token"}},
+			want:    false,
+		},
+		{
+			name:    "pkcs12",
+			sources: []Source{&PKCS12{Path: "/path/to/client.p12"}},
+			want:    false,
+		},
+		{
+			name: "no sources",
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cacheable(tt.sources); got != tt.want {
+				t.Errorf("Cacheable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrivateFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		want    []string
+	}{
+		{
+			name: "files",
+			sources: []Source{
+				&File{Slot: ClientCertificate, Path: "/path/to/tls.crt"},
+				&File{Slot: ClientKey, Path: "/path/to/tls.key"},
+				&File{Slot: Token, Path: "/path/to/token"},
+			},
+			want: []string{"/path/to/tls.key", "/path/to/token"},
+		},
+		{
+			name:    "bundle and pkcs12",
+			sources: []Source{&Bundle{Path: "/path/to/bundle.pem"}, &PKCS12{Path: "/path/to/client.p12"}},
+			want:    []string{"/path/to/bundle.pem", "/path/to/client.p12"},
+		},
+		{
+			name:    "http without client key",
+			sources: []Source{&HTTP{Slot: Token, URL: "https://example.com/token"}},
+			want:    []string{},
+		},
+		{
+			name:    "oidc",
+			sources: []Source{&OIDC{RefreshTokenPath: "/path/to/refresh-token"}},
+			want:    []string{"/path/to/refresh-token"},
+		},
+		{
+			name:    "env",
+			sources: []Source{&Env{Slot: Token, Name: "TOKEN"}},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PrivateFiles(tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrivateFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/takumakume/kubectl-credentials-broker/credentials"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TokenRequest mints a ServiceAccount token with the TokenRequest API of the bootstrap cluster.
+type TokenRequest struct {
+	// Kubeconfig and Context select the bootstrap cluster and credential.
+	Kubeconfig string
+	Context    string
+	// Namespace defaults to the namespace of the context.
+	Namespace      string
+	ServiceAccount string
+	Audiences      []string
+	// Duration defaults to the apiserver's default (1 hour).
+	Duration time.Duration
+}
+
+func init() {
+	Register("token-request", func(params Params) (Source, error) {
+		s := &TokenRequest{
+			Kubeconfig:     params["kubeconfig"],
+			Context:        params["context"],
+			Namespace:      params["namespace"],
+			ServiceAccount: params["service-account"],
+		}
+		if len(params["audiences"]) > 0 {
+			s.Audiences = strings.Split(params["audiences"], " ")
+		}
+		if len(params["duration"]) > 0 {
+			d, err := time.ParseDuration(params["duration"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration: %w", err)
+			}
+			s.Duration = d
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
+
+func (s *TokenRequest) Validate() error {
+	if len(s.ServiceAccount) == 0 {
+		return errors.New("service-account is required")
+	}
+	if s.Duration != 0 && s.Duration < minExpirationDuration {
+		return fmt.Errorf("duration must be %s or more", minExpirationDuration)
+	}
+
+	return nil
+}
+
+func (s *TokenRequest) Fetch(ctx context.Context) (*credentials.CredentialOption, error) {
+	clientset, namespace, err := newBootstrapClient(s.Kubeconfig, s.Context)
+	if err != nil {
+		return nil, fmt.Errorf("token-request: %w", err)
+	}
+	if len(s.Namespace) > 0 {
+		namespace = s.Namespace
+	}
+
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences: s.Audiences,
+		},
+	}
+	if s.Duration > 0 {
+		expirationSeconds := int64(s.Duration / time.Second)
+		tr.Spec.ExpirationSeconds = &expirationSeconds
+	}
+
+	tr, err = clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, s.ServiceAccount, tr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("token-request: %s/%s: %w", namespace, s.ServiceAccount, err)
+	}
+	if len(tr.Status.Token) == 0 {
+		return nil, fmt.Errorf("token-request: %s/%s: token is not found in the response", namespace, s.ServiceAccount)
+	}
+
+	opts, err := NewOption(Token, tr.Status.Token)
+	if err != nil {
+		return nil, err
+	}
+	if !tr.Status.ExpirationTimestamp.IsZero() {
+		opts.UpdateExpirationTimestamp(&tr.Status.ExpirationTimestamp.Time)
+	}
+
+	return opts, nil
+}
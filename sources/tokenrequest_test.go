@@ -0,0 +1,186 @@
+package sources
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTokenRequest_Fetch(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("TempDir() error = %v", err)
+		return
+	}
+	defer os.RemoveAll(testDir)
+
+	exp := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	var gotRequest authenticationv1.TokenRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bootstrap-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/ns1/serviceaccounts/sa1/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotRequest); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := gotRequest
+		res.Status = authenticationv1.TokenRequestStatus{
+			Token:               "token-from-token-request",
+			ExpirationTimestamp: metav1.NewTime(exp),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&res)
+	}))
+	defer server.Close()
+
+	kubeconfigPath := filepath.Join(testDir, "kubeconfig")
+	kubeconfig := fmt.Sprintf(`---
+apiVersion: v1
+kind: Config
+current-context: context1
+clusters:
+- cluster:
+    server: %s
+    certificate-authority-data: %s
+  name: management
+contexts:
+- context:
+    cluster: management
+    namespace: ns1
+    user: bootstrap
+  name: context1
+- context:
+    cluster: management
+    namespace: ns2
+    user: bootstrap
+  name: context2
+users:
+- name: bootstrap
+  user:
+    token: bootstrap-token
+`, server.URL, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		t.Errorf("ioutil.WriteFile() error = %v", err)
+		return
+	}
+
+	expirationSeconds := int64(3600)
+	tests := []struct {
+		name     string
+		source   *TokenRequest
+		wantSpec authenticationv1.TokenRequestSpec
+		wantErr  bool
+	}{
+		{
+			name: "namespace of the current context",
+			source: &TokenRequest{
+				Kubeconfig:     kubeconfigPath,
+				ServiceAccount: "sa1",
+				Audiences:      []string{"https://kubernetes.default.svc"},
+				Duration:       time.Hour,
+			},
+			wantSpec: authenticationv1.TokenRequestSpec{
+				Audiences:         []string{"https://kubernetes.default.svc"},
+				ExpirationSeconds: &expirationSeconds,
+			},
+		},
+		{
+			name: "namespace flag overrides the context",
+			source: &TokenRequest{
+				Kubeconfig:     kubeconfigPath,
+				Context:        "context2",
+				Namespace:      "ns1",
+				ServiceAccount: "sa1",
+			},
+		},
+		{
+			name: "namespace of the context",
+			source: &TokenRequest{
+				Kubeconfig:     kubeconfigPath,
+				Context:        "context2",
+				ServiceAccount: "sa1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "context not found",
+			source: &TokenRequest{
+				Kubeconfig:     kubeconfigPath,
+				Context:        "context3",
+				ServiceAccount: "sa1",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRequest = authenticationv1.TokenRequest{}
+			got, err := tt.source.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TokenRequest.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Token != "token-from-token-request" || !got.ExpirationTimestamp.Equal(exp) {
+				t.Errorf("TokenRequest.Fetch() = %+v", got)
+			}
+			if !reflect.DeepEqual(gotRequest.Spec, tt.wantSpec) {
+				t.Errorf("TokenRequest spec = %+v, want %+v", gotRequest.Spec, tt.wantSpec)
+			}
+		})
+	}
+}
+
+func TestTokenRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  *TokenRequest
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			source: &TokenRequest{ServiceAccount: "sa1", Duration: time.Hour},
+		},
+		{
+			name:    "without service account",
+			source:  &TokenRequest{},
+			wantErr: true,
+		},
+		{
+			name:    "too short duration",
+			source:  &TokenRequest{ServiceAccount: "sa1", Duration: time.Minute},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.source.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("TokenRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}